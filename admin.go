@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// HoldRequest is the request body for setting a legal hold
+type HoldRequest struct {
+	Reason    string    `json:"reason"`
+	SetBy     string    `json:"set_by"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// HoldsResponse lists held mailboxes and domains separately
+type HoldsResponse struct {
+	Mailboxes []MailboxHold `json:"mailboxes"`
+	Domains   []DomainHold  `json:"domains"`
+}
+
+// MailboxResponse is a mailbox in the purge queue
+type MailboxResponse struct {
+	Email     string        `json:"email"`
+	CreatedAt time.Time     `json:"created_at"`
+	HeldFor   time.Duration `json:"held_for"`
+	Hold      *Hold         `json:"hold,omitempty"`
+	State     string        `json:"state,omitempty"`
+	Residual  string        `json:"residual,omitempty"`
+}
+
+// MailboxHold is a queued mailbox under legal hold
+type MailboxHold struct {
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	Hold
+}
+
+// registerAdminRoutes registers the admin API, protected by a bearer token
+func (s *Server) registerAdminRoutes(r chi.Router) {
+	r.Use(s.SourceMiddleware, s.ClientCertMiddleware, s.AdminAuthMiddleware, s.BodyMiddleware)
+
+	r.Get("/mailboxes", s.handleListMailboxes)
+	r.Delete("/mailboxes/{email}", s.handleCancelMailbox)
+	r.Get("/holds", s.handleListHolds)
+	r.Put("/holds/mailboxes/{email}", s.handleSetMailboxHold)
+	r.Delete("/holds/mailboxes/{email}", s.handleReleaseMailboxHold)
+	r.Put("/holds/domains/{domain}", s.handleSetDomainHold)
+	r.Delete("/holds/domains/{domain}", s.handleReleaseDomainHold)
+	r.Get("/log-level", s.handleGetLogLevel)
+	r.Put("/log-level", s.handleSetLogLevel)
+
+	if s.worker != nil {
+		r.Get("/worker", s.handleWorkerStatus)
+		r.Post("/worker/run", s.handleTriggerWorker)
+	}
+}
+
+// AdminAuthMiddleware verifies the admin bearer token
+func (s *Server) AdminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			requestLogger(r.Context()).Warn("Invalid admin token", requestPathField(r))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleListMailboxes lists all mailboxes in the purge queue
+func (s *Server) handleListMailboxes(w http.ResponseWriter, r *http.Request) {
+	mailboxes, err := s.db.GetMailboxes()
+	if err != nil {
+		requestLogger(r.Context()).Error("Failed to get mailboxes", zap.Error(err))
+		http.Error(w, "Failed to get mailboxes", http.StatusInternalServerError)
+		return
+	}
+
+	response := []MailboxResponse{}
+	for _, m := range mailboxes {
+		response = append(response, MailboxResponse{
+			Email:     m.Email,
+			CreatedAt: m.CreatedAt,
+			HeldFor:   m.HeldFor,
+			Hold:      m.Hold,
+			State:     m.State,
+			Residual:  m.Residual,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleCancelMailbox removes a mailbox from the purge queue without purging it
+func (s *Server) handleCancelMailbox(w http.ResponseWriter, r *http.Request) {
+	email, err := s.normalizer.Normalize(pathParam(r, "email"))
+	if err != nil {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.CancelMailbox(email); err != nil {
+		if errors.Is(err, ErrMailboxNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		requestLogger(r.Context()).Error("Failed to cancel mailbox", emailField(email), zap.Error(err))
+		http.Error(w, "Failed to cancel mailbox", http.StatusInternalServerError)
+		return
+	}
+
+	s.notifier.Notify(EventTypeMailboxCancelled, MailboxEventData{Email: email})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListHolds lists all mailboxes and domains under legal hold
+func (s *Server) handleListHolds(w http.ResponseWriter, r *http.Request) {
+	mailboxes, domains, err := s.db.GetHolds()
+	if err != nil {
+		requestLogger(r.Context()).Error("Failed to get holds", zap.Error(err))
+		http.Error(w, "Failed to get holds", http.StatusInternalServerError)
+		return
+	}
+
+	response := HoldsResponse{
+		Mailboxes: []MailboxHold{},
+		Domains:   []DomainHold{},
+	}
+	for _, m := range mailboxes {
+		response.Mailboxes = append(response.Mailboxes, MailboxHold{Email: m.Email, CreatedAt: m.CreatedAt, Hold: *m.Hold})
+	}
+	response.Domains = append(response.Domains, domains...)
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleSetMailboxHold places a queued mailbox under legal hold
+func (s *Server) handleSetMailboxHold(w http.ResponseWriter, r *http.Request) {
+	email, err := s.normalizer.Normalize(pathParam(r, "email"))
+	if err != nil {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	hold, ok := decodeHoldRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.SetMailboxHold(email, hold); err != nil {
+		writeHoldError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReleaseMailboxHold lifts the legal hold of a mailbox
+func (s *Server) handleReleaseMailboxHold(w http.ResponseWriter, r *http.Request) {
+	email, err := s.normalizer.Normalize(pathParam(r, "email"))
+	if err != nil {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.ReleaseMailboxHold(email); err != nil {
+		writeHoldError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSetDomainHold places all mailboxes of a domain under legal hold
+func (s *Server) handleSetDomainHold(w http.ResponseWriter, r *http.Request) {
+	domain, err := s.normalizer.NormalizeDomain(pathParam(r, "domain"))
+	if err != nil {
+		http.Error(w, "Invalid domain", http.StatusBadRequest)
+		return
+	}
+
+	hold, ok := decodeHoldRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.SetDomainHold(domain, hold); err != nil {
+		writeHoldError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReleaseDomainHold lifts the legal hold of a domain
+func (s *Server) handleReleaseDomainHold(w http.ResponseWriter, r *http.Request) {
+	domain, err := s.normalizer.NormalizeDomain(pathParam(r, "domain"))
+	if err != nil {
+		http.Error(w, "Invalid domain", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.ReleaseDomainHold(domain); err != nil {
+		writeHoldError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeHoldRequest decodes and validates a hold request, writing an error response on failure
+func decodeHoldRequest(w http.ResponseWriter, r *http.Request) (Hold, bool) {
+	var req HoldRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeBodyError(w, err)
+		return Hold{}, false
+	}
+
+	if req.Reason == "" || req.SetBy == "" {
+		http.Error(w, "reason and set_by are required", http.StatusBadRequest)
+		return Hold{}, false
+	}
+
+	now := time.Now()
+	if !req.ExpiresAt.IsZero() && !req.ExpiresAt.After(now) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return Hold{}, false
+	}
+
+	return Hold{
+		Reason:    req.Reason,
+		SetBy:     req.SetBy,
+		SetAt:     now.Truncate(time.Second),
+		ExpiresAt: req.ExpiresAt,
+	}, true
+}
+
+// writeHoldError maps database errors of hold operations to HTTP responses
+func writeHoldError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrMailboxNotFound) || errors.Is(err, ErrHoldNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	requestLogger(r.Context()).Error("Failed to update hold", zap.Error(err))
+	http.Error(w, "Failed to update hold", http.StatusInternalServerError)
+}
+
+// pathParam returns the unescaped URL parameter
+func pathParam(r *http.Request, key string) string {
+	value := chi.URLParam(r, key)
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		return unescaped
+	}
+
+	return value
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
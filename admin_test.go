@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type AdminTestSuite struct {
+	suite.Suite
+	server *Server
+	db     *Database
+}
+
+func (s *AdminTestSuite) SetupTest() {
+	logger = zap.NewNop()
+
+	tempDir := s.T().TempDir()
+
+	var err error
+	s.db, err = NewDatabase(filepath.Join(tempDir, "mailboxes.csv"), filepath.Join(tempDir, "domain_holds.csv"), NewEmailNormalizer(true))
+	s.Require().NoError(err)
+
+	s.server = NewServer("admin-token", s.db, NewEmailNormalizer(true), userliRoutes("test-secret"), ServerOptions{})
+	s.server.RegisterRoutes()
+}
+
+func (s *AdminTestSuite) request(method, path string, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		s.Require().NoError(json.NewEncoder(&buf).Encode(body))
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+
+	s.server.router.ServeHTTP(w, req)
+	return w
+}
+
+func (s *AdminTestSuite) TestAdminAuthMiddleware() {
+	req := httptest.NewRequest("GET", "/admin/holds", nil)
+	w := httptest.NewRecorder()
+	s.server.router.ServeHTTP(w, req)
+	s.Equal(http.StatusUnauthorized, w.Code)
+
+	req = httptest.NewRequest("GET", "/admin/holds", nil)
+	req.Header.Set("Authorization", "Bearer wrong-token")
+	w = httptest.NewRecorder()
+	s.server.router.ServeHTTP(w, req)
+	s.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (s *AdminTestSuite) TestAdminRoutes_DisabledWithoutToken() {
+	server := NewServer("", s.db, NewEmailNormalizer(true), userliRoutes("test-secret"), ServerOptions{})
+	server.RegisterRoutes()
+
+	req := httptest.NewRequest("GET", "/admin/holds", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	s.Equal(http.StatusNotFound, w.Code)
+}
+
+func (s *AdminTestSuite) TestListMailboxes() {
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "user@example.com"))
+	s.Require().NoError(s.db.MarkVerificationFailed("user@example.com", "INBOX=3"))
+
+	w := s.request("GET", "/admin/mailboxes", nil)
+	s.Equal(http.StatusOK, w.Code)
+
+	var response []MailboxResponse
+	s.NoError(json.NewDecoder(w.Body).Decode(&response))
+	s.Require().Len(response, 1)
+	s.Equal("user@example.com", response[0].Email)
+	s.Equal(MailboxStateVerificationFailed, response[0].State)
+	s.Equal("INBOX=3", response[0].Residual)
+}
+
+func (s *AdminTestSuite) TestCancelMailbox() {
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "user@example.com"))
+
+	w := s.request("DELETE", "/admin/mailboxes/User@example.com", nil)
+	s.Equal(http.StatusNoContent, w.Code)
+
+	mailboxes, err := s.db.GetMailboxes()
+	s.NoError(err)
+	s.Empty(mailboxes)
+
+	w = s.request("DELETE", "/admin/mailboxes/user@example.com", nil)
+	s.Equal(http.StatusNotFound, w.Code)
+
+	w = s.request("DELETE", "/admin/mailboxes/*@example.com", nil)
+	s.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (s *AdminTestSuite) TestMailboxHold() {
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "user@example.com"))
+
+	w := s.request("PUT", "/admin/holds/mailboxes/User@example.com", HoldRequest{Reason: "court order", SetBy: "alice"})
+	s.Equal(http.StatusNoContent, w.Code)
+
+	w = s.request("GET", "/admin/holds", nil)
+	s.Equal(http.StatusOK, w.Code)
+
+	var response HoldsResponse
+	s.NoError(json.NewDecoder(w.Body).Decode(&response))
+	s.Require().Len(response.Mailboxes, 1)
+	s.Equal("user@example.com", response.Mailboxes[0].Email)
+	s.Equal("court order", response.Mailboxes[0].Reason)
+	s.Equal("alice", response.Mailboxes[0].SetBy)
+	s.Empty(response.Domains)
+
+	w = s.request("DELETE", "/admin/holds/mailboxes/user@example.com", nil)
+	s.Equal(http.StatusNoContent, w.Code)
+
+	held, _, err := s.db.GetHolds()
+	s.NoError(err)
+	s.Empty(held)
+}
+
+func (s *AdminTestSuite) TestMailboxHold_NotQueued() {
+	w := s.request("PUT", "/admin/holds/mailboxes/user@example.com", HoldRequest{Reason: "court order", SetBy: "alice"})
+	s.Equal(http.StatusNotFound, w.Code)
+
+	w = s.request("DELETE", "/admin/holds/mailboxes/user@example.com", nil)
+	s.Equal(http.StatusNotFound, w.Code)
+}
+
+func (s *AdminTestSuite) TestMailboxHold_InvalidRequest() {
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "user@example.com"))
+
+	w := s.request("PUT", "/admin/holds/mailboxes/user@example.com", HoldRequest{Reason: "court order"})
+	s.Equal(http.StatusBadRequest, w.Code)
+
+	w = s.request("PUT", "/admin/holds/mailboxes/user@example.com", HoldRequest{Reason: "court order", SetBy: "alice", ExpiresAt: time.Now().Add(-time.Hour)})
+	s.Equal(http.StatusBadRequest, w.Code)
+
+	w = s.request("PUT", "/admin/holds/mailboxes/*@example.com", HoldRequest{Reason: "court order", SetBy: "alice"})
+	s.Equal(http.StatusBadRequest, w.Code)
+
+	w = s.request("PUT", "/admin/holds/mailboxes/user@example.com", map[string]string{"reason": "court order", "set_by": "alice", "until": "tomorrow"})
+	s.Equal(http.StatusBadRequest, w.Code)
+
+	req := httptest.NewRequest("PUT", "/admin/holds/mailboxes/user@example.com", strings.NewReader(`{"reason":"court order","set_by":"alice"}`))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w = httptest.NewRecorder()
+	s.server.router.ServeHTTP(w, req)
+	s.Equal(http.StatusUnsupportedMediaType, w.Code)
+}
+
+func (s *AdminTestSuite) TestDomainHold() {
+	expiresAt := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+
+	w := s.request("PUT", "/admin/holds/domains/b%C3%BCcher.example", HoldRequest{Reason: "investigation", SetBy: "alice", ExpiresAt: expiresAt})
+	s.Equal(http.StatusNoContent, w.Code)
+
+	w = s.request("GET", "/admin/holds", nil)
+	s.Equal(http.StatusOK, w.Code)
+
+	var response HoldsResponse
+	s.NoError(json.NewDecoder(w.Body).Decode(&response))
+	s.Require().Len(response.Domains, 1)
+	s.Equal("xn--bcher-kva.example", response.Domains[0].Domain)
+	s.True(expiresAt.Equal(response.Domains[0].ExpiresAt))
+
+	w = s.request("DELETE", "/admin/holds/domains/xn--bcher-kva.example", nil)
+	s.Equal(http.StatusNoContent, w.Code)
+
+	w = s.request("DELETE", "/admin/holds/domains/xn--bcher-kva.example", nil)
+	s.Equal(http.StatusNotFound, w.Code)
+}
+
+func (s *AdminTestSuite) TestLogLevel() {
+	defer func() { _ = InitLogLevel("info") }()
+
+	w := s.request("GET", "/admin/log-level", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.JSONEq(`{"level":"info"}`, w.Body.String())
+
+	w = s.request("PUT", "/admin/log-level", LogLevelRequest{Level: "debug"})
+	s.Equal(http.StatusOK, w.Code)
+	s.JSONEq(`{"level":"debug"}`, w.Body.String())
+	s.Equal(zapcore.DebugLevel, logLevel.Level())
+
+	w = s.request("PUT", "/admin/log-level", LogLevelRequest{Level: "verbose"})
+	s.Equal(http.StatusBadRequest, w.Code)
+	s.Equal(zapcore.DebugLevel, logLevel.Level())
+
+	// Levels above error would silence all errors
+	for _, level := range []string{"dpanic", "panic", "fatal"} {
+		w = s.request("PUT", "/admin/log-level", LogLevelRequest{Level: level})
+		s.Equal(http.StatusBadRequest, w.Code, level)
+	}
+	s.Equal(zapcore.DebugLevel, logLevel.Level())
+
+	req := httptest.NewRequest("PUT", "/admin/log-level", strings.NewReader(`{"level":"error"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	s.server.router.ServeHTTP(w, req)
+	s.Equal(http.StatusUnauthorized, w.Code)
+	s.Equal(zapcore.DebugLevel, logLevel.Level())
+}
+
+func TestAdminTestSuite(t *testing.T) {
+	suite.Run(t, new(AdminTestSuite))
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// purgeFailure tracks consecutive failed purges of a mailbox
+type purgeFailure struct {
+	count     int
+	lastError string
+	lastAt    time.Time
+}
+
+// Alerter sends digest emails to admins about failing purges, a long queue
+// and overdue mailboxes, at most once per interval
+type Alerter struct {
+	db               *Database
+	retentionHours   int
+	smtpAddr         string
+	smtpHost         string
+	smtpTimeout      time.Duration
+	auth             smtp.Auth
+	from             string
+	to               []string
+	interval         time.Duration
+	failureThreshold int
+	queueThreshold   int
+	overdueAfter     time.Duration
+
+	mu       sync.Mutex
+	failures map[string]*purgeFailure
+	lastSent time.Time
+}
+
+// NewAlerter creates a new alerter sending through the SMTP relay at smtpAddr,
+// giving up on a delivery after smtpTimeout. A zero queue threshold or overdue
+// duration disables the respective check.
+func NewAlerter(db *Database, retentionHours int, smtpAddr string, smtpTimeout time.Duration, username, password, from string, to []string, interval time.Duration, failureThreshold, queueThreshold int, overdueAfter time.Duration) (*Alerter, error) {
+	host, _, err := net.SplitHostPort(smtpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %w", smtpAddr, err)
+	}
+
+	if smtpTimeout <= 0 {
+		return nil, errors.New("SMTP timeout must be positive")
+	}
+
+	if from == "" || len(to) == 0 {
+		return nil, errors.New("sender and recipients are required")
+	}
+
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &Alerter{
+		db:               db,
+		retentionHours:   retentionHours,
+		smtpAddr:         smtpAddr,
+		smtpHost:         host,
+		smtpTimeout:      smtpTimeout,
+		auth:             auth,
+		from:             from,
+		to:               to,
+		interval:         interval,
+		failureThreshold: failureThreshold,
+		queueThreshold:   queueThreshold,
+		overdueAfter:     overdueAfter,
+		failures:         make(map[string]*purgeFailure),
+	}, nil
+}
+
+// RecordFailure counts a failed purge of the mailbox
+func (a *Alerter) RecordFailure(email, reason string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	failure, ok := a.failures[email]
+	if !ok {
+		failure = &purgeFailure{}
+		a.failures[email] = failure
+	}
+	failure.count++
+	failure.lastError = reason
+	failure.lastAt = time.Now()
+}
+
+// RecordSuccess resets the failure count of the mailbox
+func (a *Alerter) RecordSuccess(email string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.failures, email)
+}
+
+// Check sends a digest if there are problems and the last digest is older than the interval
+func (a *Alerter) Check() {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.lastSent.IsZero() && time.Since(a.lastSent) < a.interval {
+		return
+	}
+
+	digest, err := a.digest()
+	if err != nil {
+		logger.Error("Failed to build alert digest", zap.Error(err))
+		return
+	}
+
+	if digest == "" {
+		return
+	}
+
+	if err := a.send(digest); err != nil {
+		logger.Error("Failed to send alert digest", zap.String("smtpAddr", a.smtpAddr), zap.Error(err))
+		return
+	}
+
+	a.lastSent = time.Now()
+	logger.Info("Alert digest sent", zap.Strings("to", a.to))
+}
+
+// digest describes all current problems, it is empty if there are none.
+// The caller must hold the lock.
+func (a *Alerter) digest() (string, error) {
+	mailboxes, err := a.db.GetMailboxes()
+	if err != nil {
+		return "", err
+	}
+
+	_, domainHolds, err := a.db.GetHolds()
+	if err != nil {
+		return "", err
+	}
+
+	heldDomains := make(map[string]bool)
+	for _, h := range domainHolds {
+		heldDomains[h.Domain] = true
+	}
+
+	queued := make(map[string]bool)
+	var overdue []string
+	now := time.Now()
+	retention := time.Duration(a.retentionHours) * time.Hour
+	for _, m := range mailboxes {
+		queued[a.db.normalizer.key(m.Email)] = true
+
+		if a.overdueAfter <= 0 || m.Hold != nil || heldDomains[emailDomain(m.Email)] {
+			continue
+		}
+
+		dueAt := m.CreatedAt.Add(m.HeldFor).Add(retention)
+		if now.Sub(dueAt) > a.overdueAfter {
+			overdue = append(overdue, fmt.Sprintf("  %s (due since %s)", m.Email, dueAt.Format(timeFormat)))
+		}
+	}
+
+	// Mailboxes removed from the queue, e.g. cancelled, are no longer failing
+	var failing []string
+	for email, failure := range a.failures {
+		if !queued[email] {
+			delete(a.failures, email)
+			continue
+		}
+
+		if failure.count >= a.failureThreshold {
+			failing = append(failing, fmt.Sprintf("  %s: %d attempts, last at %s: %s",
+				email, failure.count, failure.lastAt.Format(timeFormat), failure.lastError))
+		}
+	}
+	sort.Strings(failing)
+
+	var b strings.Builder
+	if len(failing) > 0 {
+		fmt.Fprintf(&b, "Mailboxes failing to purge repeatedly (%d):\n%s\n\n", len(failing), strings.Join(failing, "\n"))
+	}
+	if a.queueThreshold > 0 && len(mailboxes) > a.queueThreshold {
+		fmt.Fprintf(&b, "The purge queue holds %d mailboxes, more than the threshold of %d.\n\n", len(mailboxes), a.queueThreshold)
+	}
+	if len(overdue) > 0 {
+		fmt.Fprintf(&b, "Mailboxes overdue for more than %s (%d):\n%s\n\n", a.overdueAfter, len(overdue), strings.Join(overdue, "\n"))
+	}
+
+	return b.String(), nil
+}
+
+// send delivers the digest through the SMTP relay
+func (a *Alerter) send(digest string) error {
+	hostname, _ := os.Hostname()
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", a.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(a.to, ", "))
+	fmt.Fprintf(&msg, "Subject: [mailbox-janitor] Problems on %s\r\n", hostname)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(digest, "\n", "\r\n"))
+
+	// The deadline covers the whole delivery, so a stalled relay cannot block the worker tick
+	conn, err := net.DialTimeout("tcp", a.smtpAddr, a.smtpTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(a.smtpTimeout)); err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, a.smtpHost)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: a.smtpHost}); err != nil {
+			return err
+		}
+	}
+
+	if a.auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("SMTP relay does not support authentication")
+		}
+		if err := client.Auth(a.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(a.from); err != nil {
+		return err
+	}
+	for _, to := range a.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+// smtpStandIn is a minimal SMTP server collecting received messages
+type smtpStandIn struct {
+	listener net.Listener
+	messages chan string
+}
+
+func newSMTPStandIn() (*smtpStandIn, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &smtpStandIn{listener: listener, messages: make(chan string, 10)}
+	go s.serve()
+	return s, nil
+}
+
+func (s *smtpStandIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		switch command := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case command == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.messages <- data.String()
+			reply("250 queued")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+type AlerterTestSuite struct {
+	suite.Suite
+	db   *Database
+	smtp *smtpStandIn
+}
+
+func (s *AlerterTestSuite) SetupTest() {
+	logger = zap.NewNop()
+
+	tempDir := s.T().TempDir()
+
+	var err error
+	s.db, err = NewDatabase(filepath.Join(tempDir, "mailboxes.csv"), filepath.Join(tempDir, "domain_holds.csv"), NewEmailNormalizer(true))
+	s.Require().NoError(err)
+
+	s.smtp, err = newSMTPStandIn()
+	s.Require().NoError(err)
+}
+
+func (s *AlerterTestSuite) TearDownTest() {
+	s.smtp.listener.Close()
+}
+
+func (s *AlerterTestSuite) newAlerter(queueThreshold int, overdueAfter time.Duration) *Alerter {
+	alerter, err := NewAlerter(s.db, 0, s.smtp.listener.Addr().String(), time.Second, "", "", "janitor@example.org",
+		[]string{"oncall@example.org"}, time.Hour, 2, queueThreshold, overdueAfter)
+	s.Require().NoError(err)
+	return alerter
+}
+
+func (s *AlerterTestSuite) message() string {
+	select {
+	case msg := <-s.smtp.messages:
+		return msg
+	case <-time.After(time.Second):
+		s.Fail("no message received")
+		return ""
+	}
+}
+
+func (s *AlerterTestSuite) TestNewAlerter_Invalid() {
+	_, err := NewAlerter(s.db, 0, "localhost", time.Second, "", "", "janitor@example.org", []string{"oncall@example.org"}, time.Hour, 3, 0, 0)
+	s.Error(err)
+
+	_, err = NewAlerter(s.db, 0, "localhost:25", time.Second, "", "", "", []string{"oncall@example.org"}, time.Hour, 3, 0, 0)
+	s.Error(err)
+
+	_, err = NewAlerter(s.db, 0, "localhost:25", 0, "", "", "janitor@example.org", []string{"oncall@example.org"}, time.Hour, 3, 0, 0)
+	s.Error(err)
+}
+
+func (s *AlerterTestSuite) TestNilAlerter() {
+	var alerter *Alerter
+
+	alerter.RecordFailure("test@example.com", "failed")
+	alerter.RecordSuccess("test@example.com")
+	alerter.Check()
+}
+
+func (s *AlerterTestSuite) TestCheck_NoProblems() {
+	alerter := s.newAlerter(0, 0)
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "test@example.com"))
+
+	alerter.RecordFailure("test@example.com", "doveadm purge failed")
+	alerter.Check()
+
+	s.Empty(s.smtp.messages)
+	s.True(alerter.lastSent.IsZero())
+}
+
+func (s *AlerterTestSuite) TestCheck_RepeatedFailures() {
+	alerter := s.newAlerter(0, 0)
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "test@example.com"))
+
+	alerter.RecordFailure("test@example.com", "doveadm purge failed")
+	alerter.RecordFailure("test@example.com", "doveadm purge failed")
+	alerter.Check()
+
+	msg := s.message()
+	s.Contains(msg, "To: oncall@example.org")
+	s.Contains(msg, "Subject: [mailbox-janitor] Problems on")
+	s.Contains(msg, "test@example.com: 2 attempts")
+	s.Contains(msg, "doveadm purge failed")
+
+	// Throttled until the interval has passed
+	alerter.RecordFailure("test@example.com", "doveadm purge failed")
+	alerter.Check()
+	s.Empty(s.smtp.messages)
+
+	alerter.lastSent = time.Now().Add(-2 * time.Hour)
+	alerter.Check()
+	s.Contains(s.message(), "test@example.com: 3 attempts")
+}
+
+func (s *AlerterTestSuite) TestCheck_SuccessResets() {
+	alerter := s.newAlerter(0, 0)
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "test@example.com"))
+
+	alerter.RecordFailure("test@example.com", "doveadm purge failed")
+	alerter.RecordFailure("test@example.com", "doveadm purge failed")
+	alerter.RecordSuccess("test@example.com")
+	alerter.Check()
+
+	s.Empty(s.smtp.messages)
+}
+
+func (s *AlerterTestSuite) TestCheck_CancelledMailbox() {
+	alerter := s.newAlerter(0, 0)
+
+	alerter.RecordFailure("test@example.com", "doveadm purge failed")
+	alerter.RecordFailure("test@example.com", "doveadm purge failed")
+	alerter.Check()
+
+	s.Empty(s.smtp.messages)
+	s.Empty(alerter.failures)
+}
+
+func (s *AlerterTestSuite) TestCheck_QueueThreshold() {
+	alerter := s.newAlerter(1, 0)
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "one@example.com"))
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "two@example.com"))
+
+	alerter.Check()
+
+	s.Contains(s.message(), "The purge queue holds 2 mailboxes, more than the threshold of 1.")
+}
+
+func (s *AlerterTestSuite) TestCheck_Overdue() {
+	alerter := s.newAlerter(0, time.Nanosecond)
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "test@example.com"))
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "held@example.com"))
+	s.Require().NoError(s.db.SetMailboxHold("held@example.com", Hold{Reason: "court order", SetBy: "alice", SetAt: time.Now()}))
+	time.Sleep(10 * time.Millisecond)
+
+	alerter.Check()
+
+	msg := s.message()
+	s.Contains(msg, "Mailboxes overdue for more than 1ns (1):")
+	s.Contains(msg, "test@example.com (due since")
+	s.NotContains(msg, "held@example.com")
+}
+
+func (s *AlerterTestSuite) TestCheck_SendFails() {
+	alerter := s.newAlerter(1, 0)
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "one@example.com"))
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "two@example.com"))
+	s.smtp.listener.Close()
+
+	alerter.Check()
+
+	// Retried on the next check
+	s.True(alerter.lastSent.IsZero())
+}
+
+func (s *AlerterTestSuite) TestCheck_StalledRelay() {
+	// Accepts connections but never greets
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	defer stalled.Close()
+	go func() {
+		for {
+			conn, err := stalled.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	alerter, err := NewAlerter(s.db, 0, stalled.Addr().String(), 100*time.Millisecond, "", "", "janitor@example.org",
+		[]string{"oncall@example.org"}, time.Hour, 2, 1, 0)
+	s.Require().NoError(err)
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "one@example.com"))
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "two@example.com"))
+
+	start := time.Now()
+	alerter.Check()
+
+	s.Less(time.Since(start), 2*time.Second)
+	s.True(alerter.lastSent.IsZero())
+}
+
+func TestAlerterTestSuite(t *testing.T) {
+	suite.Run(t, new(AlerterTestSuite))
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+	"go.uber.org/zap"
+)
+
+const archiveIndexFile = "archives.csv"
+
+// archiveReuseAge is the age up to which an archive is reused instead of exporting
+// the mailbox again, so a purge failing on every tick does not pile up archives
+const archiveReuseAge = 24 * time.Hour
+
+var archiveIndexHeader = []string{"email", "path", "sha256", "created_at"}
+
+// Archive describes an encrypted mailbox export
+type Archive struct {
+	Email     string
+	Path      string
+	Checksum  string
+	CreatedAt time.Time
+}
+
+// Archiver exports mailboxes into encrypted archives before they are purged
+type Archiver struct {
+	dir        string
+	recipients []age.Recipient
+	domains    *DomainFilter
+	retention  time.Duration
+	doveadm    Doveadm
+	tarPath    string
+	useSudo    bool
+	mu         sync.Mutex
+}
+
+// NewArchiver creates a new archiver writing to dir, encrypting to the given age recipients
+func NewArchiver(dir string, recipients []string, domains *DomainFilter, retention time.Duration, doveadm Doveadm, useSudo bool) (*Archiver, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("at least one archive recipient is required")
+	}
+
+	var parsed []age.Recipient
+	for _, r := range recipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive recipient %q: %w", r, err)
+		}
+		parsed = append(parsed, recipient)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	return &Archiver{
+		dir:        dir,
+		recipients: parsed,
+		domains:    domains,
+		retention:  retention,
+		doveadm:    doveadm,
+		tarPath:    "tar",
+		useSudo:    useSudo,
+	}, nil
+}
+
+// Enabled reports whether mailboxes of the email's domain must be exported before purging
+func (a *Archiver) Enabled(email string) bool {
+	return a != nil && a.domains.Allowed(email)
+}
+
+// Export writes an encrypted tar archive of the mailbox home directory and records
+// it in the index. An archive of the mailbox younger than archiveReuseAge is reused.
+func (a *Archiver) Export(email string) (*Archive, error) {
+	if err := validateEmail(email); err != nil {
+		return nil, fmt.Errorf("email validation failed: %w", err)
+	}
+
+	recent, err := a.recent(email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive index: %w", err)
+	}
+	if recent != nil {
+		logger.Info("Reusing recent mailbox archive",
+			emailField(email),
+			zap.String("path", recent.Path),
+			zap.Time("createdAt", recent.CreatedAt))
+		return recent, nil
+	}
+
+	home, err := a.doveadm.UserHome(email)
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt := time.Now()
+	path, err := a.archivePath(email, createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	checksum, err := a.writeArchive(path, home)
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+
+	archive := &Archive{
+		Email:     email,
+		Path:      path,
+		Checksum:  checksum,
+		CreatedAt: createdAt,
+	}
+
+	if err := a.record(archive); err != nil {
+		return nil, fmt.Errorf("failed to record archive: %w", err)
+	}
+
+	logger.Info("Mailbox archive exported",
+		emailField(email),
+		zap.String("path", path),
+		zap.String("sha256", checksum))
+
+	return archive, nil
+}
+
+// archivePath returns the path of a new archive. The file is named after a hash of
+// the email, as the local part may contain characters like / that are unsafe in
+// file names. The email is kept in the index.
+func (a *Archiver) archivePath(email string, createdAt time.Time) (string, error) {
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	name := fmt.Sprintf("%s-%s.tar.age", hex.EncodeToString(sum[:16]), createdAt.UTC().Format("20060102T150405Z"))
+	path := filepath.Join(a.dir, name)
+
+	if filepath.Dir(path) != filepath.Clean(a.dir) {
+		return "", fmt.Errorf("archive path %q outside of archive directory", path)
+	}
+
+	return path, nil
+}
+
+// writeArchive streams a tar of the home directory through age into path and returns the SHA256 of the file
+func (a *Archiver) writeArchive(path, home string) (string, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	encrypted, err := age.Encrypt(io.MultiWriter(file, hash), a.recipients...)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt archive: %w", err)
+	}
+
+	cmd := newCommand(a.useSudo, a.tarPath, "-C", home, "-cf", "-", ".")
+	cmd.Stdout = encrypted
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	logger.Debug("Executing command",
+		commandField(cmd, "", home))
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("tar failed: %w, output: %s", err, stderr.String())
+	}
+
+	if err := encrypted.Close(); err != nil {
+		return "", fmt.Errorf("failed to finish encryption: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		return "", fmt.Errorf("failed to sync archive: %w", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// recent returns the latest archive of the mailbox younger than archiveReuseAge
+// whose file still exists, nil if there is none
+func (a *Archiver) recent(email string) (*Archive, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	archives, err := a.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *Archive
+	for _, archive := range archives {
+		if !strings.EqualFold(archive.Email, email) || time.Since(archive.CreatedAt) > archiveReuseAge {
+			continue
+		}
+		if _, err := os.Stat(archive.Path); err != nil {
+			continue
+		}
+		if latest == nil || archive.CreatedAt.After(latest.CreatedAt) {
+			latest = archive
+		}
+	}
+
+	return latest, nil
+}
+
+// record appends the archive to the index file in the archive directory
+func (a *Archiver) record(archive *Archive) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	indexPath := filepath.Join(a.dir, archiveIndexFile)
+	_, statErr := os.Stat(indexPath)
+
+	file, err := os.OpenFile(indexPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if errors.Is(statErr, os.ErrNotExist) {
+		if err := writer.Write(archiveIndexHeader); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Write(archiveRecord(archive)); err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// archiveRecord returns the index row of the archive
+func archiveRecord(archive *Archive) []string {
+	return []string{archive.Email, archive.Path, archive.Checksum, archive.CreatedAt.Format(timeFormat)}
+}
+
+// readIndex reads all archives of the index, the caller must hold the lock
+func (a *Archiver) readIndex() ([]*Archive, error) {
+	file, err := os.Open(filepath.Join(a.dir, archiveIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []*Archive
+	for i, record := range records {
+		if i == 0 {
+			continue
+		}
+
+		createdAt, _ := time.Parse(timeFormat, field(record, 3))
+		archives = append(archives, &Archive{
+			Email:     field(record, 0),
+			Path:      field(record, 1),
+			Checksum:  field(record, 2),
+			CreatedAt: createdAt,
+		})
+	}
+
+	return archives, nil
+}
+
+// writeIndex replaces the index atomically, the caller must hold the lock
+func (a *Archiver) writeIndex(archives []*Archive) error {
+	indexPath := filepath.Join(a.dir, archiveIndexFile)
+	tmpPath := indexPath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	_ = writer.Write(archiveIndexHeader)
+	for _, archive := range archives {
+		_ = writer.Write(archiveRecord(archive))
+	}
+	writer.Flush()
+
+	if err := errors.Join(writer.Error(), file.Sync(), file.Close()); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, indexPath)
+}
+
+// Cleanup removes archives older than the configured retention and their rows from the index
+func (a *Archiver) Cleanup() {
+	if a == nil || a.retention <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(filepath.Join(a.dir, "*.tar.age"))
+	if err != nil {
+		logger.Error("Failed to list archives", zap.Error(err))
+		return
+	}
+
+	cutoffTime := time.Now().Add(-a.retention)
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().After(cutoffTime) {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			logger.Error("Failed to remove expired archive", zap.String("path", path), zap.Error(err))
+			continue
+		}
+
+		logger.Info("Expired archive removed", zap.String("path", path))
+	}
+
+	if err := a.pruneIndex(); err != nil {
+		logger.Error("Failed to prune archive index", zap.Error(err))
+	}
+}
+
+// pruneIndex removes the rows of archives whose files are gone
+func (a *Archiver) pruneIndex() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	archives, err := a.readIndex()
+	if err != nil || archives == nil {
+		return err
+	}
+
+	var kept []*Archive
+	for _, archive := range archives {
+		if _, err := os.Stat(archive.Path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		kept = append(kept, archive)
+	}
+
+	if len(kept) == len(archives) {
+		return nil
+	}
+
+	return a.writeIndex(kept)
+}
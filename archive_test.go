@@ -0,0 +1,197 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type ArchiverTestSuite struct {
+	suite.Suite
+	archiver *Archiver
+	identity *age.X25519Identity
+	dir      string
+	home     string
+}
+
+func (s *ArchiverTestSuite) SetupTest() {
+	logger = zap.NewNop()
+
+	var err error
+	s.identity, err = age.GenerateX25519Identity()
+	s.Require().NoError(err)
+
+	s.dir = filepath.Join(s.T().TempDir(), "archives")
+	s.home = s.T().TempDir()
+	s.Require().NoError(os.WriteFile(filepath.Join(s.home, "message"), []byte("hello"), 0o600))
+
+	// Fake doveadm printing the home directory
+	doveadm := filepath.Join(s.T().TempDir(), "doveadm")
+	s.Require().NoError(os.WriteFile(doveadm, []byte("#!/bin/sh\necho "+s.home+"\n"), 0o700))
+
+	domains, err := NewDomainFilter([]string{"example.com"})
+	s.Require().NoError(err)
+
+	s.archiver, err = NewArchiver(s.dir, []string{s.identity.Recipient().String()}, domains, time.Hour, NewDoveadmExec(doveadm, false), false)
+	s.Require().NoError(err)
+}
+
+func (s *ArchiverTestSuite) TestNewArchiver_InvalidRecipient() {
+	_, err := NewArchiver(s.dir, []string{"invalid"}, nil, 0, NewDoveadmExec("/bin/echo", false), false)
+	s.Error(err)
+
+	_, err = NewArchiver(s.dir, nil, nil, 0, NewDoveadmExec("/bin/echo", false), false)
+	s.Error(err)
+}
+
+func (s *ArchiverTestSuite) TestEnabled() {
+	s.True(s.archiver.Enabled("user@example.com"))
+	s.False(s.archiver.Enabled("user@example.org"))
+
+	var archiver *Archiver
+	s.False(archiver.Enabled("user@example.com"))
+}
+
+func (s *ArchiverTestSuite) TestExport() {
+	archive, err := s.archiver.Export("user@example.com")
+	s.Require().NoError(err)
+	s.Equal("user@example.com", archive.Email)
+
+	// Checksum matches the file on disk
+	data, err := os.ReadFile(archive.Path)
+	s.Require().NoError(err)
+	sum := sha256.Sum256(data)
+	s.Equal(hex.EncodeToString(sum[:]), archive.Checksum)
+
+	// Archive decrypts to a tar containing the home directory
+	file, err := os.Open(archive.Path)
+	s.Require().NoError(err)
+	defer file.Close()
+
+	decrypted, err := age.Decrypt(file, s.identity)
+	s.Require().NoError(err)
+
+	reader := tar.NewReader(decrypted)
+	found := false
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		s.Require().NoError(err)
+		if header.Name == "./message" {
+			content, err := io.ReadAll(reader)
+			s.NoError(err)
+			s.Equal("hello", string(content))
+			found = true
+		}
+	}
+	s.True(found, "message should be part of the archive")
+
+	// Archive is recorded in the index
+	index, err := os.ReadFile(filepath.Join(s.dir, archiveIndexFile))
+	s.NoError(err)
+	s.Contains(string(index), archive.Checksum)
+}
+
+func (s *ArchiverTestSuite) TestExport_UnsafeLocalPart() {
+	for _, email := range []string{"../../x@example.com", "a/b@example.com"} {
+		archive, err := s.archiver.Export(email)
+		s.Require().NoError(err, email)
+		s.Equal(filepath.Clean(s.dir), filepath.Dir(archive.Path))
+		s.NotContains(filepath.Base(archive.Path), "/")
+		s.NotContains(archive.Path, "..")
+		s.Equal(email, archive.Email)
+	}
+
+	index, err := os.ReadFile(filepath.Join(s.dir, archiveIndexFile))
+	s.Require().NoError(err)
+	s.Contains(string(index), "../../x@example.com")
+}
+
+func (s *ArchiverTestSuite) TestExport_ResolveHomeFails() {
+	s.archiver.doveadm = NewDoveadmExec("/nonexistent/command", false)
+
+	_, err := s.archiver.Export("user@example.com")
+	s.Error(err)
+}
+
+func (s *ArchiverTestSuite) TestExport_TarFails() {
+	s.archiver.tarPath = "/nonexistent/command"
+
+	_, err := s.archiver.Export("user@example.com")
+	s.Error(err)
+
+	// Incomplete archives are removed
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.tar.age"))
+	s.NoError(err)
+	s.Empty(matches)
+}
+
+func (s *ArchiverTestSuite) TestCleanup() {
+	archive, err := s.archiver.Export("user@example.com")
+	s.Require().NoError(err)
+
+	// Not expired yet
+	s.archiver.Cleanup()
+	s.FileExists(archive.Path)
+
+	old := time.Now().Add(-2 * time.Hour)
+	s.Require().NoError(os.Chtimes(archive.Path, old, old))
+
+	s.archiver.Cleanup()
+	s.NoFileExists(archive.Path)
+
+	// The row of the removed archive is pruned from the index
+	index, err := os.ReadFile(filepath.Join(s.dir, archiveIndexFile))
+	s.Require().NoError(err)
+	s.NotContains(string(index), archive.Path)
+	s.Contains(string(index), "email,path,sha256,created_at")
+}
+
+func (s *ArchiverTestSuite) TestExport_ReusesRecentArchive() {
+	first, err := s.archiver.Export("user@example.com")
+	s.Require().NoError(err)
+
+	// A purge failing on the next tick does not export the mailbox again
+	second, err := s.archiver.Export("user@example.com")
+	s.Require().NoError(err)
+	s.Equal(first.Path, second.Path)
+	s.Equal(first.Checksum, second.Checksum)
+
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.tar.age"))
+	s.Require().NoError(err)
+	s.Len(matches, 1)
+
+	// Archives of other mailboxes are not reused
+	other, err := s.archiver.Export("other@example.com")
+	s.Require().NoError(err)
+	s.NotEqual(first.Path, other.Path)
+
+	// Removed archives are not reused
+	s.Require().NoError(os.Remove(first.Path))
+	third, err := s.archiver.Export("user@example.com")
+	s.Require().NoError(err)
+	s.FileExists(third.Path)
+
+	// Neither are outdated ones, the new archive is named after the current second
+	third.CreatedAt = time.Now().Add(-archiveReuseAge - time.Hour)
+	s.Require().NoError(s.archiver.writeIndex([]*Archive{third}))
+	time.Sleep(time.Second)
+	fourth, err := s.archiver.Export("user@example.com")
+	s.Require().NoError(err)
+	s.NotEqual(third.Path, fourth.Path)
+}
+
+func TestArchiverTestSuite(t *testing.T) {
+	suite.Run(t, new(ArchiverTestSuite))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// defaultMaxBodySize is the default limit for request bodies in bytes
+const defaultMaxBodySize = 64 << 10
+
+// errTrailingData is returned for bodies with data after the JSON value
+var errTrailingData = errors.New("unexpected data after JSON value")
+
+// BodyMiddleware limits the size of request bodies and requires them to be JSON
+func (s *Server) BodyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > s.maxBodySize {
+			requestLogger(r.Context()).Warn("Request body too large", zap.Int64("contentLength", r.ContentLength), requestPathField(r))
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// Requests without a body, e.g. DELETE, need no content type
+		if r.ContentLength != 0 && !isJSON(r.Header.Get("Content-Type")) {
+			requestLogger(r.Context()).Warn("Unsupported content type", zap.String("contentType", r.Header.Get("Content-Type")), requestPathField(r))
+			http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		// Bodies without or with a wrong Content-Length are cut off while reading
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBodySize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isJSON reports whether the content type is application/json
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
+// decodeJSON decodes a single JSON value, rejecting unknown fields and trailing data.
+// Fields added to the userli payload must be added to UserEvent before userli sends them.
+func decodeJSON(body io.Reader, v any) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return err
+		}
+		return errTrailingData
+	}
+
+	return nil
+}
+
+// writeBodyError responds to a body that could not be read or decoded
+func writeBodyError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	http.Error(w, "Invalid request body", http.StatusBadRequest)
+}
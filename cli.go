@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// runCommand runs a CLI command talking to the running daemon and returns the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "trigger":
+		return runTrigger(args[1:], os.Stdout, os.Stderr)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, available commands: trigger\n", args[0])
+		return 2
+	}
+}
+
+// runTrigger requests an immediate worker run through the admin API
+func runTrigger(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("trigger", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	listenAddr := getEnvOrDefault("LISTEN_ADDR", ":8080")
+	socketPath, _ := strings.CutPrefix(listenAddr, unixSocketPrefix)
+	if socketPath == listenAddr {
+		socketPath = ""
+	}
+	url := flags.String("url", defaultDaemonURL(listenAddr, os.Getenv("TLS_CERT_FILE") != ""), "base URL of the running daemon")
+	socket := flags.String("socket", socketPath, "Unix socket of the running daemon, defaults to LISTEN_ADDR with unix: prefix")
+	token := flags.String("token", os.Getenv("ADMIN_TOKEN"), "admin token, defaults to ADMIN_TOKEN")
+	caFile := flags.String("cacert", "", "CA bundle to verify the daemon certificate, defaults to the system roots")
+	certFile := flags.String("cert", "", "client certificate for mutual TLS")
+	keyFile := flags.String("key", "", "client key for mutual TLS")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *token == "" {
+		fmt.Fprintln(stderr, "an admin token is required")
+		return 2
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*url, "/")+"/admin/worker/run", nil)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+
+	transport, err := clientTransport(*socket, *caFile, *certFile, *keyFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		fmt.Fprintf(stderr, "unexpected status %d: %s\n", resp.StatusCode, strings.TrimSpace(string(body)))
+		return 1
+	}
+
+	var response TriggerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if response.Queued {
+		fmt.Fprintln(stdout, "Worker run queued")
+	} else {
+		fmt.Fprintln(stdout, "Worker run already pending")
+	}
+
+	return 0
+}
+
+// clientTransport connects to the daemon through the Unix socket if set and
+// configures the CA bundle and client certificate for TLS connections
+func clientTransport(socket, caFile, certFile, keyFile string) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if socket != "" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		transport.TLSClientConfig.RootCAs = roots
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("-cert and -key must be set together")
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return transport, nil
+}
+
+// defaultDaemonURL derives the URL of the local daemon from its listen address
+func defaultDaemonURL(listenAddr string, useTLS bool) string {
+	scheme := "http://"
+	if useTLS {
+		scheme = "https://"
+	}
+
+	// The host is ignored when connecting through the socket
+	if strings.HasPrefix(listenAddr, unixSocketPrefix) {
+		return scheme + "localhost"
+	}
+
+	if strings.HasPrefix(listenAddr, ":") {
+		return scheme + "localhost" + listenAddr
+	}
+
+	return scheme + listenAddr
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunTrigger(t *testing.T) {
+	var queued bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/admin/worker/run" || r.Header.Get("Authorization") != "Bearer admin-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, TriggerResponse{Queued: !queued})
+		queued = true
+	}))
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, runTrigger([]string{"-url", server.URL, "-token", "admin-token"}, &stdout, &stderr))
+	assert.Equal(t, "Worker run queued\n", stdout.String())
+
+	stdout.Reset()
+	assert.Equal(t, 0, runTrigger([]string{"-url", server.URL + "/", "-token", "admin-token"}, &stdout, &stderr))
+	assert.Equal(t, "Worker run already pending\n", stdout.String())
+
+	assert.Equal(t, 1, runTrigger([]string{"-url", server.URL, "-token", "wrong-token"}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "unexpected status 401")
+
+	t.Setenv("ADMIN_TOKEN", "")
+	assert.Equal(t, 2, runTrigger([]string{"-url", server.URL}, &stdout, &stderr))
+}
+
+func TestDefaultDaemonURL(t *testing.T) {
+	assert.Equal(t, "http://localhost:8080", defaultDaemonURL(":8080", false))
+	assert.Equal(t, "http://127.0.0.1:9090", defaultDaemonURL("127.0.0.1:9090", false))
+	assert.Equal(t, "https://localhost:8443", defaultDaemonURL(":8443", true))
+	assert.Equal(t, "http://localhost", defaultDaemonURL("unix:/run/mailbox-janitor.sock", false))
+}
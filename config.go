@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -10,35 +11,236 @@ import (
 
 // Config holds all application configuration
 type Config struct {
-	LogLevel       string
-	ListenAddr     string
-	WebhookSecret  string
-	DatabasePath   string
-	RetentionHours int
-	TickInterval   time.Duration
-	DoveadmPath    string
-	UseSudo        bool
+	LogLevel                  string
+	LogEmails                 string
+	LogEmailHashKey           string
+	LogFormat                 string
+	LogOutput                 string
+	LogFile                   string
+	LogFileMaxSize            int
+	LogFileMaxBackups         int
+	LogFileMaxAge             time.Duration
+	LogFileCompress           bool
+	LogSyslogAddr             string
+	TracingOTLPEndpoint       string
+	ListenAddr                string
+	ListenSocketMode          os.FileMode
+	ListenSocketOwner         string
+	WebhookSecret             string
+	WebhookSignatureScheme    string
+	WebhookSignatureHeader    string
+	WebhookSignatureTolerance time.Duration
+	WebhookRoutes             map[string]string
+	WebhookRouteSecrets       map[string]string
+	DatabasePath              string
+	DomainHoldsPath           string
+	AdminToken                string
+	TLSCertFile               string
+	TLSKeyFile                string
+	TLSClientCAFile           string
+	RetentionHours            int
+	TickInterval              time.Duration
+	TickWarnDuration          time.Duration
+	PurgeBackend              string
+	DomainPurgeBackends       map[string]string
+	DoveadmBackend            string
+	DoveadmPath               string
+	UseSudo                   bool
+	DoveadmAPIURL             string
+	DoveadmAPIKey             string
+	DoveadmAPIPassword        string
+	DoveadmAPITimeout         time.Duration
+	MaildirPathTemplate       string
+	MaildirRoot               string
+	MaildirTrashDir           string
+	MaildirTrashRetention     time.Duration
+	ProtectedAccounts         []string
+	AllowedDomains            []string
+	AllowedSources            []string
+	TrustedProxies            []string
+	TrustedProxyHeader        string
+	MaxBodySize               int
+	FoldLocalPart             bool
+	VerifyMode                string
+	ArchiveDir                string
+	ArchiveRecipients         []string
+	ArchiveDomains            []string
+	ArchiveRetention          time.Duration
+	PrePurgeHook              string
+	PostPurgeHook             string
+	HookTimeout               time.Duration
+	PreHookFailure            string
+	NotifyURLs                []string
+	NotifySecret              string
+	NotifyEvents              []string
+	NotifyOutboxPath          string
+	NotifyMaxAttempts         int
+	NotifyTimeout             time.Duration
+	AlertSMTPAddr             string
+	AlertSMTPUsername         string
+	AlertSMTPPassword         string
+	AlertSMTPTimeout          time.Duration
+	AlertFrom                 string
+	AlertTo                   []string
+	AlertInterval             time.Duration
+	AlertFailureThreshold     int
+	AlertQueueThreshold       int
+	AlertOverdueAfter         time.Duration
 }
 
 // BuildConfig creates a configuration from environment variables
 func BuildConfig() *Config {
 	cfg := &Config{
-		LogLevel:       getEnvOrDefault("LOG_LEVEL", "info"),
-		ListenAddr:     getEnvOrDefault("LISTEN_ADDR", ":8080"),
-		DatabasePath:   getEnvOrDefault("DATABASE_PATH", "./mailboxes.csv"),
-		DoveadmPath:    getEnvOrDefault("DOVEADM_PATH", "/usr/bin/doveadm"),
-		WebhookSecret:  getEnvOrFatal("WEBHOOK_SECRET"),
-		RetentionHours: getEnvAsIntOrDefault("RETENTION_HOURS", 24),
-		UseSudo:        getEnvAsBoolOrDefault("USE_SUDO", true),
-	}
-
-	// Parse tick interval
-	tickIntervalStr := getEnvOrDefault("TICK_INTERVAL", "5m")
-	tickInterval, err := time.ParseDuration(tickIntervalStr)
-	if err != nil {
-		logger.Fatal("Invalid TICK_INTERVAL format", zap.String("value", tickIntervalStr))
+		LogLevel:                  getEnvOrDefault("LOG_LEVEL", "info"),
+		LogEmails:                 getEnvOrDefault("LOG_EMAILS", LogEmailsPlain),
+		LogEmailHashKey:           getEnvOrDefault("LOG_EMAIL_HASH_KEY", ""),
+		LogFormat:                 getEnvOrDefault("LOG_FORMAT", LogFormatJSON),
+		LogOutput:                 getEnvOrDefault("LOG_OUTPUT", LogOutputStdout),
+		LogFile:                   getEnvOrDefault("LOG_FILE", ""),
+		LogFileMaxSize:            getEnvAsIntOrDefault("LOG_FILE_MAX_SIZE", 100),
+		LogFileMaxBackups:         getEnvAsIntOrDefault("LOG_FILE_MAX_BACKUPS", 5),
+		LogFileMaxAge:             getEnvAsDurationOrDefault("LOG_FILE_MAX_AGE", 0),
+		LogFileCompress:           getEnvAsBoolOrDefault("LOG_FILE_COMPRESS", false),
+		LogSyslogAddr:             getEnvOrDefault("LOG_SYSLOG_ADDR", ""),
+		TracingOTLPEndpoint:       getEnvOrDefault("TRACING_OTLP_ENDPOINT", ""),
+		ListenAddr:                getEnvOrDefault("LISTEN_ADDR", ":8080"),
+		ListenSocketMode:          getEnvAsFileModeOrDefault("LISTEN_SOCKET_MODE", 0660),
+		ListenSocketOwner:         getEnvOrDefault("LISTEN_SOCKET_OWNER", ""),
+		DatabasePath:              getEnvOrDefault("DATABASE_PATH", "./mailboxes.csv"),
+		DomainHoldsPath:           getEnvOrDefault("DOMAIN_HOLDS_PATH", "./domain_holds.csv"),
+		AdminToken:                getEnvOrDefault("ADMIN_TOKEN", ""),
+		TLSCertFile:               getEnvOrDefault("TLS_CERT_FILE", ""),
+		TLSKeyFile:                getEnvOrDefault("TLS_KEY_FILE", ""),
+		TLSClientCAFile:           getEnvOrDefault("TLS_CLIENT_CA_FILE", ""),
+		PurgeBackend:              getEnvOrDefault("PURGE_BACKEND", PurgeBackendDoveadm),
+		DomainPurgeBackends:       getEnvAsMapOrDefault("DOMAIN_PURGE_BACKENDS", nil),
+		DoveadmBackend:            getEnvOrDefault("DOVEADM_BACKEND", DoveadmBackendExec),
+		DoveadmPath:               getEnvOrDefault("DOVEADM_PATH", "/usr/bin/doveadm"),
+		DoveadmAPIURL:             getEnvOrDefault("DOVEADM_API_URL", ""),
+		DoveadmAPIKey:             getEnvOrDefault("DOVEADM_API_KEY", ""),
+		DoveadmAPIPassword:        getEnvOrDefault("DOVEADM_API_PASSWORD", ""),
+		DoveadmAPITimeout:         getEnvAsDurationOrDefault("DOVEADM_API_TIMEOUT", 30*time.Second),
+		MaildirPathTemplate:       getEnvOrDefault("MAILDIR_PATH_TEMPLATE", "/var/vmail/%d/%n"),
+		MaildirRoot:               getEnvOrDefault("MAILDIR_ROOT", "/var/vmail"),
+		MaildirTrashDir:           getEnvOrDefault("MAILDIR_TRASH_DIR", ""),
+		MaildirTrashRetention:     getEnvAsDurationOrDefault("MAILDIR_TRASH_RETENTION", 7*24*time.Hour),
+		WebhookSecret:             getEnvOrFatal("WEBHOOK_SECRET"),
+		WebhookSignatureScheme:    getEnvOrDefault("WEBHOOK_SIGNATURE_SCHEME", SignatureSchemeHex),
+		WebhookSignatureHeader:    getEnvOrDefault("WEBHOOK_SIGNATURE_HEADER", DefaultSignatureHeader),
+		WebhookSignatureTolerance: getEnvAsDurationOrDefault("WEBHOOK_SIGNATURE_TOLERANCE", 5*time.Minute),
+		WebhookRoutes:             getEnvAsMapOrDefault("WEBHOOK_ROUTES", nil),
+		WebhookRouteSecrets:       getEnvAsMapOrDefault("WEBHOOK_ROUTE_SECRETS", nil),
+		RetentionHours:            getEnvAsIntOrDefault("RETENTION_HOURS", 24),
+		UseSudo:                   getEnvAsBoolOrDefault("USE_SUDO", true),
+		ProtectedAccounts:         getEnvAsListOrDefault("PROTECTED_ACCOUNTS", []string{"postmaster@", "abuse@"}),
+		AllowedDomains:            getEnvAsListOrDefault("ALLOWED_DOMAINS", nil),
+		AllowedSources:            getEnvAsListOrDefault("ALLOWED_SOURCES", nil),
+		TrustedProxies:            getEnvAsListOrDefault("TRUSTED_PROXIES", nil),
+		TrustedProxyHeader:        getEnvOrDefault("TRUSTED_PROXY_HEADER", ProxyHeaderXForwardedFor),
+		MaxBodySize:               getEnvAsIntOrDefault("MAX_BODY_SIZE", defaultMaxBodySize),
+		FoldLocalPart:             getEnvAsBoolOrDefault("FOLD_LOCAL_PART", true),
+		TickInterval:              getEnvAsDurationOrDefault("TICK_INTERVAL", 5*time.Minute),
+		TickWarnDuration:          getEnvAsDurationOrDefault("TICK_WARN_DURATION", 10*time.Minute),
+		VerifyMode:                getEnvOrDefault("VERIFY_MODE", VerifyModeNone),
+		ArchiveDir:                getEnvOrDefault("ARCHIVE_DIR", ""),
+		ArchiveRecipients:         getEnvAsListOrDefault("ARCHIVE_RECIPIENTS", nil),
+		ArchiveDomains:            getEnvAsListOrDefault("ARCHIVE_DOMAINS", nil),
+		ArchiveRetention:          getEnvAsDurationOrDefault("ARCHIVE_RETENTION", 0),
+		PrePurgeHook:              getEnvOrDefault("PRE_PURGE_HOOK", ""),
+		PostPurgeHook:             getEnvOrDefault("POST_PURGE_HOOK", ""),
+		HookTimeout:               getEnvAsDurationOrDefault("HOOK_TIMEOUT", time.Minute),
+		PreHookFailure:            getEnvOrDefault("PRE_PURGE_HOOK_FAILURE", HookFailureBlock),
+		NotifyURLs:                getEnvAsListOrDefault("NOTIFY_URLS", nil),
+		NotifySecret:              getEnvOrDefault("NOTIFY_SECRET", ""),
+		NotifyEvents:              getEnvAsListOrDefault("NOTIFY_EVENTS", nil),
+		NotifyOutboxPath:          getEnvOrDefault("NOTIFY_OUTBOX_PATH", "./outbox.csv"),
+		NotifyMaxAttempts:         getEnvAsIntOrDefault("NOTIFY_MAX_ATTEMPTS", 10),
+		NotifyTimeout:             getEnvAsDurationOrDefault("NOTIFY_TIMEOUT", 10*time.Second),
+		AlertSMTPAddr:             getEnvOrDefault("ALERT_SMTP_ADDR", ""),
+		AlertSMTPUsername:         getEnvOrDefault("ALERT_SMTP_USERNAME", ""),
+		AlertSMTPPassword:         getEnvOrDefault("ALERT_SMTP_PASSWORD", ""),
+		AlertSMTPTimeout:          getEnvAsDurationOrDefault("ALERT_SMTP_TIMEOUT", 30*time.Second),
+		AlertFrom:                 getEnvOrDefault("ALERT_FROM", ""),
+		AlertTo:                   getEnvAsListOrDefault("ALERT_TO", nil),
+		AlertInterval:             getEnvAsDurationOrDefault("ALERT_INTERVAL", time.Hour),
+		AlertFailureThreshold:     getEnvAsIntOrDefault("ALERT_FAILURE_THRESHOLD", 3),
+		AlertQueueThreshold:       getEnvAsIntOrDefault("ALERT_QUEUE_THRESHOLD", 0),
+		AlertOverdueAfter:         getEnvAsDurationOrDefault("ALERT_OVERDUE_AFTER", 24*time.Hour),
+	}
+
+	switch cfg.VerifyMode {
+	case VerifyModeNone, VerifyModeMailboxStatus, VerifyModeHome:
+	default:
+		logger.Fatal("Invalid VERIFY_MODE", zap.String("value", cfg.VerifyMode))
+	}
+
+	// Outbound events are signed with the inbound secret unless configured otherwise
+	if cfg.NotifySecret == "" {
+		cfg.NotifySecret = cfg.WebhookSecret
+	}
+
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		logger.Fatal("Invalid LOG_LEVEL", zap.String("value", cfg.LogLevel))
+	}
+
+	switch cfg.LogFormat {
+	case LogFormatJSON, LogFormatConsole:
+	default:
+		logger.Fatal("Invalid LOG_FORMAT", zap.String("value", cfg.LogFormat))
+	}
+
+	switch cfg.LogOutput {
+	case LogOutputStdout, LogOutputStderr, LogOutputSyslog, LogOutputJournald:
+	case LogOutputFile:
+		if cfg.LogFile == "" {
+			logger.Fatal("LOG_FILE is required for LOG_OUTPUT=file")
+		}
+	default:
+		logger.Fatal("Invalid LOG_OUTPUT", zap.String("value", cfg.LogOutput))
+	}
+
+	switch cfg.LogEmails {
+	case LogEmailsPlain, LogEmailsMask:
+	case LogEmailsHash:
+		// Hashes are keyed with the webhook secret unless configured otherwise
+		if cfg.LogEmailHashKey == "" {
+			cfg.LogEmailHashKey = cfg.WebhookSecret
+		}
+	default:
+		logger.Fatal("Invalid LOG_EMAILS", zap.String("value", cfg.LogEmails))
+	}
+
+	if cfg.MaxBodySize <= 0 {
+		logger.Fatal("MAX_BODY_SIZE must be positive", zap.Int("value", cfg.MaxBodySize))
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		logger.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	if cfg.TLSClientCAFile != "" && cfg.TLSCertFile == "" {
+		logger.Fatal("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	switch cfg.PreHookFailure {
+	case HookFailureBlock, HookFailureIgnore:
+	default:
+		logger.Fatal("Invalid PRE_PURGE_HOOK_FAILURE", zap.String("value", cfg.PreHookFailure))
+	}
+
+	switch cfg.DoveadmBackend {
+	case DoveadmBackendExec:
+	case DoveadmBackendHTTP:
+		if cfg.DoveadmAPIURL == "" {
+			logger.Fatal("DOVEADM_API_URL is required for the http backend")
+		}
+		// Both need access to the local file system of the Dovecot host
+		if cfg.VerifyMode == VerifyModeHome || cfg.ArchiveDir != "" {
+			logger.Fatal("VERIFY_MODE=home and ARCHIVE_DIR require the exec backend")
+		}
+	default:
+		logger.Fatal("Invalid DOVEADM_BACKEND", zap.String("value", cfg.DoveadmBackend))
 	}
-	cfg.TickInterval = tickInterval
 
 	return cfg
 }
@@ -89,3 +291,69 @@ func getEnvAsBoolOrDefault(key string, defaultValue bool) bool {
 
 	return val
 }
+
+// getEnvAsFileModeOrDefault returns an environment variable as octal file mode or a default value
+func getEnvAsFileModeOrDefault(key string, defaultValue os.FileMode) os.FileMode {
+	valStr := os.Getenv(key)
+	if valStr == "" {
+		return defaultValue
+	}
+
+	val, err := strconv.ParseUint(valStr, 8, 32)
+	if err != nil || val > 0777 {
+		logger.Fatal("Invalid file mode value for "+key, zap.String("value", valStr))
+	}
+
+	return os.FileMode(val)
+}
+
+// getEnvAsDurationOrDefault returns an environment variable as duration or a default value
+func getEnvAsDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	valStr := os.Getenv(key)
+	if valStr == "" {
+		return defaultValue
+	}
+
+	val, err := time.ParseDuration(valStr)
+	if err != nil {
+		logger.Fatal("Invalid duration value for "+key, zap.String("value", valStr))
+	}
+
+	return val
+}
+
+// getEnvAsListOrDefault returns a comma-separated environment variable as list or a default value
+func getEnvAsListOrDefault(key string, defaultValue []string) []string {
+	valStr := os.Getenv(key)
+	if valStr == "" {
+		return defaultValue
+	}
+
+	var list []string
+	for _, item := range strings.Split(valStr, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// getEnvAsMapOrDefault returns a comma-separated list of key=value pairs as map or a default value
+func getEnvAsMapOrDefault(key string, defaultValue map[string]string) map[string]string {
+	list := getEnvAsListOrDefault(key, nil)
+	if list == nil {
+		return defaultValue
+	}
+
+	m := make(map[string]string)
+	for _, item := range list {
+		k, v, found := strings.Cut(item, "=")
+		if !found || strings.TrimSpace(k) == "" {
+			logger.Fatal("Invalid key=value pair for "+key, zap.String("value", item))
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+
+	return m
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -14,13 +15,80 @@ type ConfigTestSuite struct {
 func (s *ConfigTestSuite) SetupTest() {
 	// Clear environment variables
 	os.Unsetenv("LOG_LEVEL")
+	os.Unsetenv("LOG_EMAILS")
+	os.Unsetenv("LOG_EMAIL_HASH_KEY")
+	os.Unsetenv("LOG_FORMAT")
+	os.Unsetenv("LOG_OUTPUT")
+	os.Unsetenv("LOG_FILE")
+	os.Unsetenv("LOG_FILE_MAX_SIZE")
+	os.Unsetenv("LOG_FILE_MAX_BACKUPS")
+	os.Unsetenv("LOG_FILE_MAX_AGE")
+	os.Unsetenv("LOG_FILE_COMPRESS")
+	os.Unsetenv("LOG_SYSLOG_ADDR")
+	os.Unsetenv("TRACING_OTLP_ENDPOINT")
 	os.Unsetenv("LISTEN_ADDR")
 	os.Unsetenv("WEBHOOK_SECRET")
 	os.Unsetenv("DATABASE_PATH")
+	os.Unsetenv("DOMAIN_HOLDS_PATH")
+	os.Unsetenv("ADMIN_TOKEN")
 	os.Unsetenv("RETENTION_HOURS")
 	os.Unsetenv("TICK_INTERVAL")
+	os.Unsetenv("TICK_WARN_DURATION")
 	os.Unsetenv("DOVEADM_PATH")
+	os.Unsetenv("PURGE_BACKEND")
+	os.Unsetenv("DOMAIN_PURGE_BACKENDS")
+	os.Unsetenv("DOVEADM_BACKEND")
+	os.Unsetenv("DOVEADM_API_URL")
+	os.Unsetenv("DOVEADM_API_KEY")
+	os.Unsetenv("DOVEADM_API_PASSWORD")
+	os.Unsetenv("DOVEADM_API_TIMEOUT")
+	os.Unsetenv("MAILDIR_PATH_TEMPLATE")
+	os.Unsetenv("MAILDIR_ROOT")
+	os.Unsetenv("MAILDIR_TRASH_DIR")
+	os.Unsetenv("MAILDIR_TRASH_RETENTION")
 	os.Unsetenv("USE_SUDO")
+	os.Unsetenv("PROTECTED_ACCOUNTS")
+	os.Unsetenv("ALLOWED_DOMAINS")
+	os.Unsetenv("FOLD_LOCAL_PART")
+	os.Unsetenv("VERIFY_MODE")
+	os.Unsetenv("ARCHIVE_DIR")
+	os.Unsetenv("ARCHIVE_RECIPIENTS")
+	os.Unsetenv("ARCHIVE_DOMAINS")
+	os.Unsetenv("ARCHIVE_RETENTION")
+	os.Unsetenv("PRE_PURGE_HOOK")
+	os.Unsetenv("POST_PURGE_HOOK")
+	os.Unsetenv("HOOK_TIMEOUT")
+	os.Unsetenv("PRE_PURGE_HOOK_FAILURE")
+	os.Unsetenv("NOTIFY_URLS")
+	os.Unsetenv("NOTIFY_SECRET")
+	os.Unsetenv("NOTIFY_EVENTS")
+	os.Unsetenv("NOTIFY_OUTBOX_PATH")
+	os.Unsetenv("NOTIFY_MAX_ATTEMPTS")
+	os.Unsetenv("NOTIFY_TIMEOUT")
+	os.Unsetenv("ALERT_SMTP_ADDR")
+	os.Unsetenv("ALERT_SMTP_USERNAME")
+	os.Unsetenv("ALERT_SMTP_PASSWORD")
+	os.Unsetenv("ALERT_SMTP_TIMEOUT")
+	os.Unsetenv("ALERT_FROM")
+	os.Unsetenv("ALERT_TO")
+	os.Unsetenv("ALERT_INTERVAL")
+	os.Unsetenv("ALERT_FAILURE_THRESHOLD")
+	os.Unsetenv("ALERT_QUEUE_THRESHOLD")
+	os.Unsetenv("ALERT_OVERDUE_AFTER")
+	os.Unsetenv("LISTEN_SOCKET_MODE")
+	os.Unsetenv("LISTEN_SOCKET_OWNER")
+	os.Unsetenv("ALLOWED_SOURCES")
+	os.Unsetenv("TRUSTED_PROXIES")
+	os.Unsetenv("TRUSTED_PROXY_HEADER")
+	os.Unsetenv("MAX_BODY_SIZE")
+	os.Unsetenv("WEBHOOK_SIGNATURE_SCHEME")
+	os.Unsetenv("WEBHOOK_SIGNATURE_HEADER")
+	os.Unsetenv("WEBHOOK_SIGNATURE_TOLERANCE")
+	os.Unsetenv("WEBHOOK_ROUTES")
+	os.Unsetenv("WEBHOOK_ROUTE_SECRETS")
+	os.Unsetenv("TLS_CERT_FILE")
+	os.Unsetenv("TLS_KEY_FILE")
+	os.Unsetenv("TLS_CLIENT_CA_FILE")
 }
 
 func (s *ConfigTestSuite) TestBuildConfig_Defaults() {
@@ -29,33 +97,241 @@ func (s *ConfigTestSuite) TestBuildConfig_Defaults() {
 	cfg := BuildConfig()
 
 	s.Equal("info", cfg.LogLevel)
+	s.Equal(LogEmailsPlain, cfg.LogEmails)
+	s.Empty(cfg.LogEmailHashKey)
+	s.Equal(LogFormatJSON, cfg.LogFormat)
+	s.Equal(LogOutputStdout, cfg.LogOutput)
+	s.Empty(cfg.LogFile)
+	s.Equal(100, cfg.LogFileMaxSize)
+	s.Equal(5, cfg.LogFileMaxBackups)
+	s.Equal(time.Duration(0), cfg.LogFileMaxAge)
+	s.False(cfg.LogFileCompress)
+	s.Empty(cfg.LogSyslogAddr)
+	s.Empty(cfg.TracingOTLPEndpoint)
 	s.Equal(":8080", cfg.ListenAddr)
+	s.Equal(os.FileMode(0660), cfg.ListenSocketMode)
+	s.Empty(cfg.ListenSocketOwner)
 	s.Equal("test-secret", cfg.WebhookSecret)
+	s.Equal(SignatureSchemeHex, cfg.WebhookSignatureScheme)
+	s.Equal(DefaultSignatureHeader, cfg.WebhookSignatureHeader)
+	s.Equal(5*time.Minute, cfg.WebhookSignatureTolerance)
+	s.Empty(cfg.WebhookRoutes)
+	s.Empty(cfg.WebhookRouteSecrets)
 	s.Equal("./mailboxes.csv", cfg.DatabasePath)
+	s.Equal("./domain_holds.csv", cfg.DomainHoldsPath)
+	s.Empty(cfg.AdminToken)
+	s.Empty(cfg.TLSCertFile)
+	s.Empty(cfg.TLSKeyFile)
+	s.Empty(cfg.TLSClientCAFile)
 	s.Equal(24, cfg.RetentionHours)
 	s.Equal("/usr/bin/doveadm", cfg.DoveadmPath)
+	s.Equal(PurgeBackendDoveadm, cfg.PurgeBackend)
+	s.Empty(cfg.DomainPurgeBackends)
+	s.Equal(DoveadmBackendExec, cfg.DoveadmBackend)
+	s.Equal(30*time.Second, cfg.DoveadmAPITimeout)
+	s.Equal("/var/vmail/%d/%n", cfg.MaildirPathTemplate)
+	s.Equal("/var/vmail", cfg.MaildirRoot)
+	s.Empty(cfg.MaildirTrashDir)
+	s.Equal(7*24*time.Hour, cfg.MaildirTrashRetention)
 	s.True(cfg.UseSudo)
+	s.Equal([]string{"postmaster@", "abuse@"}, cfg.ProtectedAccounts)
+	s.Empty(cfg.AllowedDomains)
+	s.Empty(cfg.AllowedSources)
+	s.Empty(cfg.TrustedProxies)
+	s.Equal(ProxyHeaderXForwardedFor, cfg.TrustedProxyHeader)
+	s.Equal(65536, cfg.MaxBodySize)
+	s.True(cfg.FoldLocalPart)
+	s.Equal(5*time.Minute, cfg.TickInterval)
+	s.Equal(10*time.Minute, cfg.TickWarnDuration)
+	s.Equal(VerifyModeNone, cfg.VerifyMode)
+	s.Empty(cfg.ArchiveDir)
+	s.Empty(cfg.ArchiveRecipients)
+	s.Equal(time.Duration(0), cfg.ArchiveRetention)
+	s.Empty(cfg.PrePurgeHook)
+	s.Empty(cfg.PostPurgeHook)
+	s.Equal(time.Minute, cfg.HookTimeout)
+	s.Equal(HookFailureBlock, cfg.PreHookFailure)
+	s.Empty(cfg.NotifyURLs)
+	s.Equal("test-secret", cfg.NotifySecret)
+	s.Empty(cfg.NotifyEvents)
+	s.Equal("./outbox.csv", cfg.NotifyOutboxPath)
+	s.Equal(10, cfg.NotifyMaxAttempts)
+	s.Equal(10*time.Second, cfg.NotifyTimeout)
+	s.Empty(cfg.AlertSMTPAddr)
+	s.Equal(30*time.Second, cfg.AlertSMTPTimeout)
+	s.Empty(cfg.AlertTo)
+	s.Equal(time.Hour, cfg.AlertInterval)
+	s.Equal(3, cfg.AlertFailureThreshold)
+	s.Equal(0, cfg.AlertQueueThreshold)
+	s.Equal(24*time.Hour, cfg.AlertOverdueAfter)
 }
 
 func (s *ConfigTestSuite) TestBuildConfig_CustomValues() {
 	os.Setenv("LOG_LEVEL", "debug")
-	os.Setenv("LISTEN_ADDR", ":9090")
+	os.Setenv("LOG_EMAILS", "hash")
+	os.Setenv("LOG_EMAIL_HASH_KEY", "hash-key")
+	os.Setenv("LOG_FORMAT", "console")
+	os.Setenv("LOG_OUTPUT", "file")
+	os.Setenv("LOG_FILE", "/var/log/janitor.log")
+	os.Setenv("LOG_FILE_MAX_SIZE", "10")
+	os.Setenv("LOG_FILE_MAX_BACKUPS", "3")
+	os.Setenv("LOG_FILE_MAX_AGE", "720h")
+	os.Setenv("LOG_FILE_COMPRESS", "true")
+	os.Setenv("LOG_SYSLOG_ADDR", "udp://logs.example.org:514")
+	os.Setenv("TRACING_OTLP_ENDPOINT", "http://otel-collector:4318")
+	os.Setenv("LISTEN_ADDR", "unix:/run/mailbox-janitor/janitor.sock")
+	os.Setenv("LISTEN_SOCKET_MODE", "0600")
+	os.Setenv("LISTEN_SOCKET_OWNER", "janitor:www-data")
 	os.Setenv("WEBHOOK_SECRET", "custom-secret")
 	os.Setenv("DATABASE_PATH", "/tmp/test.csv")
+	os.Setenv("DOMAIN_HOLDS_PATH", "/tmp/holds.csv")
+	os.Setenv("ADMIN_TOKEN", "admin-token")
+	os.Setenv("TLS_CERT_FILE", "/etc/janitor/tls/cert.pem")
+	os.Setenv("TLS_KEY_FILE", "/etc/janitor/tls/key.pem")
+	os.Setenv("TLS_CLIENT_CA_FILE", "/etc/janitor/tls/userli-ca.pem")
 	os.Setenv("RETENTION_HOURS", "48")
 	os.Setenv("TICK_INTERVAL", "10m")
+	os.Setenv("TICK_WARN_DURATION", "30m")
 	os.Setenv("DOVEADM_PATH", "/usr/local/bin/doveadm")
 	os.Setenv("USE_SUDO", "false")
+	os.Setenv("DOMAIN_PURGE_BACKENDS", "example.org=doveadm, example.net = maildir")
+	os.Setenv("MAILDIR_PATH_TEMPLATE", "/srv/mail/%d/%n/Maildir")
+	os.Setenv("MAILDIR_ROOT", "/srv/mail")
+	os.Setenv("MAILDIR_TRASH_DIR", "/srv/mail/.trash")
+	os.Setenv("MAILDIR_TRASH_RETENTION", "72h")
+	os.Setenv("PROTECTED_ACCOUNTS", "admin@example.org, @example.net")
+	os.Setenv("ALLOWED_DOMAINS", "example.org,example.net")
+	os.Setenv("ALLOWED_SOURCES", "192.0.2.10,2001:db8::/32")
+	os.Setenv("TRUSTED_PROXIES", "127.0.0.1")
+	os.Setenv("TRUSTED_PROXY_HEADER", "Forwarded")
+	os.Setenv("MAX_BODY_SIZE", "4096")
+	os.Setenv("WEBHOOK_SIGNATURE_SCHEME", "sha256")
+	os.Setenv("WEBHOOK_SIGNATURE_HEADER", "X-Userli-Signature")
+	os.Setenv("WEBHOOK_SIGNATURE_TOLERANCE", "1m")
+	os.Setenv("WEBHOOK_ROUTES", "billing=timestamped:Billing-Signature,crm=base64")
+	os.Setenv("WEBHOOK_ROUTE_SECRETS", "billing=billing-secret")
+	os.Setenv("FOLD_LOCAL_PART", "false")
+	os.Setenv("VERIFY_MODE", "mailbox-status")
+	os.Setenv("ARCHIVE_DIR", "/var/lib/janitor/archives")
+	os.Setenv("ARCHIVE_RECIPIENTS", "age1abc,age1def")
+	os.Setenv("ARCHIVE_DOMAINS", "example.org")
+	os.Setenv("ARCHIVE_RETENTION", "168h")
+	os.Setenv("PRE_PURGE_HOOK", "/usr/local/bin/pre-purge --sieve")
+	os.Setenv("POST_PURGE_HOOK", "/usr/local/bin/post-purge")
+	os.Setenv("HOOK_TIMEOUT", "30s")
+	os.Setenv("PRE_PURGE_HOOK_FAILURE", "ignore")
+	os.Setenv("NOTIFY_URLS", "https://userli.example.org/webhooks/janitor,https://billing.example.org/hook")
+	os.Setenv("NOTIFY_SECRET", "notify-secret")
+	os.Setenv("NOTIFY_EVENTS", "mailbox.purged,mailbox.purge_failed")
+	os.Setenv("NOTIFY_OUTBOX_PATH", "/tmp/outbox.csv")
+	os.Setenv("NOTIFY_MAX_ATTEMPTS", "5")
+	os.Setenv("NOTIFY_TIMEOUT", "5s")
+	os.Setenv("ALERT_SMTP_ADDR", "smtp.example.org:587")
+	os.Setenv("ALERT_SMTP_USERNAME", "janitor")
+	os.Setenv("ALERT_SMTP_PASSWORD", "smtp-password")
+	os.Setenv("ALERT_SMTP_TIMEOUT", "5s")
+	os.Setenv("ALERT_FROM", "janitor@example.org")
+	os.Setenv("ALERT_TO", "oncall@example.org,admin@example.org")
+	os.Setenv("ALERT_INTERVAL", "6h")
+	os.Setenv("ALERT_FAILURE_THRESHOLD", "5")
+	os.Setenv("ALERT_QUEUE_THRESHOLD", "100")
+	os.Setenv("ALERT_OVERDUE_AFTER", "48h")
 
 	cfg := BuildConfig()
 
 	s.Equal("debug", cfg.LogLevel)
-	s.Equal(":9090", cfg.ListenAddr)
+	s.Equal(LogEmailsHash, cfg.LogEmails)
+	s.Equal("hash-key", cfg.LogEmailHashKey)
+	s.Equal(LogFormatConsole, cfg.LogFormat)
+	s.Equal(LogOutputFile, cfg.LogOutput)
+	s.Equal("/var/log/janitor.log", cfg.LogFile)
+	s.Equal(10, cfg.LogFileMaxSize)
+	s.Equal(3, cfg.LogFileMaxBackups)
+	s.Equal(720*time.Hour, cfg.LogFileMaxAge)
+	s.True(cfg.LogFileCompress)
+	s.Equal("udp://logs.example.org:514", cfg.LogSyslogAddr)
+	s.Equal("http://otel-collector:4318", cfg.TracingOTLPEndpoint)
+	s.Equal("unix:/run/mailbox-janitor/janitor.sock", cfg.ListenAddr)
+	s.Equal(os.FileMode(0600), cfg.ListenSocketMode)
+	s.Equal("janitor:www-data", cfg.ListenSocketOwner)
 	s.Equal("custom-secret", cfg.WebhookSecret)
 	s.Equal("/tmp/test.csv", cfg.DatabasePath)
+	s.Equal("/tmp/holds.csv", cfg.DomainHoldsPath)
+	s.Equal("admin-token", cfg.AdminToken)
+	s.Equal("/etc/janitor/tls/cert.pem", cfg.TLSCertFile)
+	s.Equal("/etc/janitor/tls/key.pem", cfg.TLSKeyFile)
+	s.Equal("/etc/janitor/tls/userli-ca.pem", cfg.TLSClientCAFile)
 	s.Equal(48, cfg.RetentionHours)
 	s.Equal("/usr/local/bin/doveadm", cfg.DoveadmPath)
 	s.False(cfg.UseSudo)
+	s.Equal(map[string]string{"example.org": "doveadm", "example.net": "maildir"}, cfg.DomainPurgeBackends)
+	s.Equal("/srv/mail/%d/%n/Maildir", cfg.MaildirPathTemplate)
+	s.Equal("/srv/mail", cfg.MaildirRoot)
+	s.Equal("/srv/mail/.trash", cfg.MaildirTrashDir)
+	s.Equal(72*time.Hour, cfg.MaildirTrashRetention)
+	s.Equal([]string{"admin@example.org", "@example.net"}, cfg.ProtectedAccounts)
+	s.Equal([]string{"example.org", "example.net"}, cfg.AllowedDomains)
+	s.Equal([]string{"192.0.2.10", "2001:db8::/32"}, cfg.AllowedSources)
+	s.Equal([]string{"127.0.0.1"}, cfg.TrustedProxies)
+	s.Equal(ProxyHeaderForwarded, cfg.TrustedProxyHeader)
+	s.Equal(4096, cfg.MaxBodySize)
+	s.Equal(SignatureSchemeSHA256, cfg.WebhookSignatureScheme)
+	s.Equal("X-Userli-Signature", cfg.WebhookSignatureHeader)
+	s.Equal(time.Minute, cfg.WebhookSignatureTolerance)
+	s.Equal(map[string]string{"billing": "timestamped:Billing-Signature", "crm": "base64"}, cfg.WebhookRoutes)
+	s.Equal(map[string]string{"billing": "billing-secret"}, cfg.WebhookRouteSecrets)
+	s.False(cfg.FoldLocalPart)
+	s.Equal(10*time.Minute, cfg.TickInterval)
+	s.Equal(30*time.Minute, cfg.TickWarnDuration)
+	s.Equal(VerifyModeMailboxStatus, cfg.VerifyMode)
+	s.Equal("/var/lib/janitor/archives", cfg.ArchiveDir)
+	s.Equal([]string{"age1abc", "age1def"}, cfg.ArchiveRecipients)
+	s.Equal([]string{"example.org"}, cfg.ArchiveDomains)
+	s.Equal(168*time.Hour, cfg.ArchiveRetention)
+	s.Equal("/usr/local/bin/pre-purge --sieve", cfg.PrePurgeHook)
+	s.Equal("/usr/local/bin/post-purge", cfg.PostPurgeHook)
+	s.Equal(30*time.Second, cfg.HookTimeout)
+	s.Equal(HookFailureIgnore, cfg.PreHookFailure)
+	s.Equal([]string{"https://userli.example.org/webhooks/janitor", "https://billing.example.org/hook"}, cfg.NotifyURLs)
+	s.Equal("notify-secret", cfg.NotifySecret)
+	s.Equal([]string{EventTypeMailboxPurged, EventTypeMailboxPurgeFailed}, cfg.NotifyEvents)
+	s.Equal("/tmp/outbox.csv", cfg.NotifyOutboxPath)
+	s.Equal(5, cfg.NotifyMaxAttempts)
+	s.Equal(5*time.Second, cfg.NotifyTimeout)
+	s.Equal("smtp.example.org:587", cfg.AlertSMTPAddr)
+	s.Equal("janitor", cfg.AlertSMTPUsername)
+	s.Equal("smtp-password", cfg.AlertSMTPPassword)
+	s.Equal(5*time.Second, cfg.AlertSMTPTimeout)
+	s.Equal("janitor@example.org", cfg.AlertFrom)
+	s.Equal([]string{"oncall@example.org", "admin@example.org"}, cfg.AlertTo)
+	s.Equal(6*time.Hour, cfg.AlertInterval)
+	s.Equal(5, cfg.AlertFailureThreshold)
+	s.Equal(100, cfg.AlertQueueThreshold)
+	s.Equal(48*time.Hour, cfg.AlertOverdueAfter)
+}
+
+func (s *ConfigTestSuite) TestBuildConfig_DoveadmHTTP() {
+	os.Setenv("WEBHOOK_SECRET", "test-secret")
+	os.Setenv("DOVEADM_BACKEND", "http")
+	os.Setenv("DOVEADM_API_URL", "http://dovecot:8080/doveadm/v1")
+	os.Setenv("DOVEADM_API_KEY", "api-key")
+	os.Setenv("DOVEADM_API_TIMEOUT", "10s")
+
+	cfg := BuildConfig()
+
+	s.Equal(DoveadmBackendHTTP, cfg.DoveadmBackend)
+	s.Equal("http://dovecot:8080/doveadm/v1", cfg.DoveadmAPIURL)
+	s.Equal("api-key", cfg.DoveadmAPIKey)
+	s.Equal(10*time.Second, cfg.DoveadmAPITimeout)
+}
+
+func (s *ConfigTestSuite) TestBuildConfig_LogEmailHashKeyDefault() {
+	os.Setenv("WEBHOOK_SECRET", "test-secret")
+	os.Setenv("LOG_EMAILS", "hash")
+
+	cfg := BuildConfig()
+
+	s.Equal("test-secret", cfg.LogEmailHashKey)
 }
 
 func TestConfigTestSuite(t *testing.T) {
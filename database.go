@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
@@ -8,43 +9,76 @@ import (
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 // Database handles all database operations for mailbox management
 type Database struct {
-	filePath string
-	mu       sync.RWMutex
+	filePath   string
+	holdsPath  string
+	normalizer *EmailNormalizer
+	mu         sync.RWMutex
 }
 
 // Mailbox represents a mailbox entry in the database
 type Mailbox struct {
 	Email     string
 	CreatedAt time.Time
+	// HeldFor is the accumulated time the mailbox spent under legal hold,
+	// it delays the purge by the same amount
+	HeldFor time.Duration
+	Hold    *Hold
+	// State is empty for pending entries or MailboxStateVerificationFailed
+	State string
+	// Residual describes data left behind after a failed verification
+	Residual string
+	// TraceParent is the W3C traceparent of the request that queued the mailbox
+	TraceParent string
 }
 
 const timeFormat = time.RFC3339
 
-// NewDatabase creates a new database instance and ensures the CSV file exists
-func NewDatabase(filePath string) (*Database, error) {
+var mailboxHeader = []string{"email", "created_at", "held_for", "hold_reason", "hold_set_by", "hold_set_at", "hold_expires_at", "state", "residual", "traceparent"}
+
+// MailboxStateVerificationFailed marks entries whose purge left data behind
+const MailboxStateVerificationFailed = "verification_failed"
+
+// ErrMailboxNotFound is returned when a mailbox is not in the purge queue
+var ErrMailboxNotFound = errors.New("mailbox not found")
+
+// ErrMailboxExists is returned when a mailbox is already in the purge queue
+var ErrMailboxExists = errors.New("mailbox already exists")
+
+// NewDatabase creates a new database instance and ensures the CSV files exist
+func NewDatabase(filePath, holdsPath string, normalizer *EmailNormalizer) (*Database, error) {
 	database := &Database{
-		filePath: filePath,
+		filePath:   filePath,
+		holdsPath:  holdsPath,
+		normalizer: normalizer,
 	}
 
 	// Create file with header if it doesn't exist
 	if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
-		if err := database.initFile(); err != nil {
+		if err := initFile(filePath, mailboxHeader); err != nil {
+			return nil, fmt.Errorf("failed to initialize CSV file: %w", err)
+		}
+	}
+
+	if _, err := os.Stat(holdsPath); errors.Is(err, os.ErrNotExist) {
+		if err := initFile(holdsPath, domainHoldHeader); err != nil {
 			return nil, fmt.Errorf("failed to initialize CSV file: %w", err)
 		}
 	}
 
-	logger.Info("Database initialized", zap.String("path", filePath))
+	logger.Info("Database initialized", zap.String("path", filePath), zap.String("holdsPath", holdsPath))
 	return database, nil
 }
 
-// initFile creates the CSV file with header
-func (d *Database) initFile() error {
-	file, err := os.Create(d.filePath)
+// initFile creates a CSV file with header
+func initFile(filePath string, header []string) error {
+	file, err := os.Create(filePath)
 	if err != nil {
 		return err
 	}
@@ -53,7 +87,7 @@ func (d *Database) initFile() error {
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
 
-	return writer.Write([]string{"email", "created_at"})
+	return writer.Write(header)
 }
 
 // readAll reads all mailboxes from the CSV file
@@ -65,6 +99,8 @@ func (d *Database) readAll() ([]Mailbox, error) {
 	defer file.Close()
 
 	reader := csv.NewReader(file)
+	// Allow rows without the optional hold columns, e.g. added manually
+	reader.FieldsPerRecord = -1
 	records, err := reader.ReadAll()
 	if err != nil {
 		return nil, err
@@ -82,14 +118,36 @@ func (d *Database) readAll() ([]Mailbox, error) {
 
 		createdAt, err := time.Parse(timeFormat, record[1])
 		if err != nil {
-			logger.Warn("Failed to parse timestamp", zap.String("email", record[0]), zap.Error(err))
+			logger.Warn("Failed to parse timestamp", emailField(record[0]), zap.Error(err))
 			continue
 		}
 
-		mailboxes = append(mailboxes, Mailbox{
+		mailbox := Mailbox{
 			Email:     record[0],
 			CreatedAt: createdAt,
-		})
+		}
+
+		if len(record) > 2 && record[2] != "" {
+			mailbox.HeldFor, err = time.ParseDuration(record[2])
+			if err != nil {
+				logger.Warn("Failed to parse hold duration", emailField(record[0]), zap.Error(err))
+			}
+		}
+
+		if len(record) > 3 {
+			mailbox.Hold, err = holdFromRecord(record[3:])
+			if err != nil {
+				// Keep the entry on hold rather than risk purging it
+				logger.Warn("Failed to parse hold", emailField(record[0]), zap.Error(err))
+				mailbox.Hold = &Hold{Reason: record[3]}
+			}
+		}
+
+		mailbox.State = field(record, 7)
+		mailbox.Residual = field(record, 8)
+		mailbox.TraceParent = field(record, 9)
+
+		mailboxes = append(mailboxes, mailbox)
 	}
 
 	return mailboxes, nil
@@ -107,13 +165,20 @@ func (d *Database) writeAll(mailboxes []Mailbox) error {
 	defer writer.Flush()
 
 	// Write header
-	if err := writer.Write([]string{"email", "created_at"}); err != nil {
+	if err := writer.Write(mailboxHeader); err != nil {
 		return err
 	}
 
 	// Write records
 	for _, m := range mailboxes {
-		if err := writer.Write([]string{m.Email, m.CreatedAt.Format(timeFormat)}); err != nil {
+		var heldFor string
+		if m.HeldFor > 0 {
+			heldFor = m.HeldFor.String()
+		}
+
+		record := append([]string{m.Email, m.CreatedAt.Format(timeFormat), heldFor}, holdToRecord(m.Hold)...)
+		record = append(record, m.State, m.Residual, m.TraceParent)
+		if err := writer.Write(record); err != nil {
 			return err
 		}
 	}
@@ -122,7 +187,19 @@ func (d *Database) writeAll(mailboxes []Mailbox) error {
 }
 
 // AddMailbox adds a new mailbox to the purge queue
-func (d *Database) AddMailbox(email string) error {
+func (d *Database) AddMailbox(ctx context.Context, email string) (err error) {
+	queuedBy := traceParent(ctx)
+	ctx, span := tracer.Start(ctx, "database add mailbox", trace.WithAttributes(
+		attribute.String("db.system.name", "csv"),
+		attribute.String("db.collection.name", d.filePath),
+	))
+	defer endSpan(span, &err)
+
+	email, err = d.normalizer.Normalize(email)
+	if err != nil {
+		return err
+	}
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
@@ -131,27 +208,28 @@ func (d *Database) AddMailbox(email string) error {
 		return fmt.Errorf("failed to read mailboxes: %w", err)
 	}
 
-	// Check for duplicate
+	// Check for duplicate, entries may have been added manually in a non-canonical form
 	for _, m := range mailboxes {
-		if m.Email == email {
-			return fmt.Errorf("mailbox already exists: %s", email)
+		if d.normalizer.key(m.Email) == email {
+			return ErrMailboxExists
 		}
 	}
 
 	mailboxes = append(mailboxes, Mailbox{
-		Email:     email,
-		CreatedAt: time.Now(),
+		Email:       email,
+		CreatedAt:   time.Now(),
+		TraceParent: queuedBy,
 	})
 
 	if err := d.writeAll(mailboxes); err != nil {
 		return fmt.Errorf("failed to write mailboxes: %w", err)
 	}
 
-	logger.Info("Mailbox added to database", zap.String("email", email))
+	requestLogger(ctx).Info("Mailbox added to database", emailField(email))
 	return nil
 }
 
-// GetDueMailboxes returns mailboxes that are ready to be purged
+// GetDueMailboxes returns mailboxes that are ready to be purged, skipping mailboxes under legal hold
 func (d *Database) GetDueMailboxes(retentionHours int) ([]Mailbox, error) {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
@@ -161,11 +239,26 @@ func (d *Database) GetDueMailboxes(retentionHours int) ([]Mailbox, error) {
 		return nil, fmt.Errorf("failed to read mailboxes: %w", err)
 	}
 
+	domainHolds, err := d.readDomainHolds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read domain holds: %w", err)
+	}
+
+	heldDomains := make(map[string]bool)
+	for _, h := range domainHolds {
+		heldDomains[h.Domain] = true
+	}
+
 	cutoffTime := time.Now().Add(-time.Duration(retentionHours) * time.Hour)
 
 	var dueMailboxes []Mailbox
 	for _, m := range mailboxes {
-		if m.CreatedAt.Before(cutoffTime) || m.CreatedAt.Equal(cutoffTime) {
+		if m.Hold != nil || heldDomains[emailDomain(m.Email)] {
+			continue
+		}
+
+		dueAt := m.CreatedAt.Add(m.HeldFor)
+		if dueAt.Before(cutoffTime) || dueAt.Equal(cutoffTime) {
 			dueMailboxes = append(dueMailboxes, m)
 		}
 	}
@@ -183,21 +276,131 @@ func (d *Database) RemoveMailbox(email string) error {
 		return fmt.Errorf("failed to read mailboxes: %w", err)
 	}
 
+	key := d.normalizer.key(email)
+
+	var newMailboxes []Mailbox
+	for _, m := range mailboxes {
+		if d.normalizer.key(m.Email) != key {
+			newMailboxes = append(newMailboxes, m)
+		}
+	}
+
+	if err := d.writeAll(newMailboxes); err != nil {
+		return fmt.Errorf("failed to write mailboxes: %w", err)
+	}
+
+	logger.Info("Mailbox removed from database", emailField(email))
+	return nil
+}
+
+// CancelMailbox removes a mailbox from the purge queue without purging it
+func (d *Database) CancelMailbox(email string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mailboxes, err := d.readAll()
+	if err != nil {
+		return fmt.Errorf("failed to read mailboxes: %w", err)
+	}
+
+	key := d.normalizer.key(email)
+
 	var newMailboxes []Mailbox
 	for _, m := range mailboxes {
-		if m.Email != email {
+		if d.normalizer.key(m.Email) != key {
 			newMailboxes = append(newMailboxes, m)
 		}
 	}
 
+	if len(newMailboxes) == len(mailboxes) {
+		return fmt.Errorf("%w: %s", ErrMailboxNotFound, email)
+	}
+
 	if err := d.writeAll(newMailboxes); err != nil {
 		return fmt.Errorf("failed to write mailboxes: %w", err)
 	}
 
-	logger.Info("Mailbox removed from database", zap.String("email", email))
+	logger.Info("Mailbox purge cancelled", emailField(email))
 	return nil
 }
 
+// GetMailboxes returns all mailboxes in the purge queue
+func (d *Database) GetMailboxes() ([]Mailbox, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	mailboxes, err := d.readAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mailboxes: %w", err)
+	}
+
+	return mailboxes, nil
+}
+
+// MarkVerificationFailed records that a purged mailbox still contains data
+func (d *Database) MarkVerificationFailed(email, residual string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mailboxes, err := d.readAll()
+	if err != nil {
+		return fmt.Errorf("failed to read mailboxes: %w", err)
+	}
+
+	key := d.normalizer.key(email)
+	found := false
+	for i, m := range mailboxes {
+		if d.normalizer.key(m.Email) == key {
+			mailboxes[i].State = MailboxStateVerificationFailed
+			mailboxes[i].Residual = residual
+			found = true
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("%w: %s", ErrMailboxNotFound, email)
+	}
+
+	if err := d.writeAll(mailboxes); err != nil {
+		return fmt.Errorf("failed to write mailboxes: %w", err)
+	}
+
+	return nil
+}
+
+// Check verifies that the database files are readable and writable
+func (d *Database) Check() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if _, err := d.readAll(); err != nil {
+		return fmt.Errorf("failed to read mailboxes: %w", err)
+	}
+
+	if _, err := d.readDomainHolds(); err != nil {
+		return fmt.Errorf("failed to read domain holds: %w", err)
+	}
+
+	for _, path := range []string{d.filePath, d.holdsPath} {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		file.Close()
+	}
+
+	return nil
+}
+
+// field returns the CSV column at index i or an empty string if the record is shorter
+func field(record []string, i int) string {
+	if i < len(record) {
+		return record[i]
+	}
+
+	return ""
+}
+
 // Close is a no-op for CSV-based database (for interface compatibility)
 func (d *Database) Close() error {
 	return nil
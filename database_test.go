@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -11,8 +12,9 @@ import (
 
 type DatabaseTestSuite struct {
 	suite.Suite
-	db       *Database
-	tempFile string
+	db        *Database
+	tempFile  string
+	holdsFile string
 }
 
 func (s *DatabaseTestSuite) SetupTest() {
@@ -21,19 +23,21 @@ func (s *DatabaseTestSuite) SetupTest() {
 	// Use temporary file for tests
 	tempDir := os.TempDir()
 	s.tempFile = filepath.Join(tempDir, "test_mailboxes.csv")
+	s.holdsFile = filepath.Join(tempDir, "test_domain_holds.csv")
 
 	var err error
-	s.db, err = NewDatabase(s.tempFile)
+	s.db, err = NewDatabase(s.tempFile, s.holdsFile, NewEmailNormalizer(true))
 	s.Require().NoError(err)
 }
 
 func (s *DatabaseTestSuite) TearDownTest() {
 	s.db.Close()
 	os.Remove(s.tempFile)
+	os.Remove(s.holdsFile)
 }
 
 func (s *DatabaseTestSuite) TestAddMailbox() {
-	err := s.db.AddMailbox("test@example.com")
+	err := s.db.AddMailbox(context.Background(), "test@example.com")
 	s.NoError(err)
 
 	// Verify mailbox was added
@@ -44,14 +48,33 @@ func (s *DatabaseTestSuite) TestAddMailbox() {
 }
 
 func (s *DatabaseTestSuite) TestAddMailbox_Duplicate() {
-	err := s.db.AddMailbox("test@example.com")
+	err := s.db.AddMailbox(context.Background(), "test@example.com")
 	s.NoError(err)
 
 	// Try to add same mailbox again
-	err = s.db.AddMailbox("test@example.com")
+	err = s.db.AddMailbox(context.Background(), "test@example.com")
 	s.Error(err) // Should fail due to PRIMARY KEY constraint
 }
 
+func (s *DatabaseTestSuite) TestAddMailbox_DuplicateNormalized() {
+	err := s.db.AddMailbox(context.Background(), "User@Example.com")
+	s.NoError(err)
+
+	// Same mailbox in a different case
+	err = s.db.AddMailbox(context.Background(), "user@example.com")
+	s.ErrorIs(err, ErrMailboxExists)
+
+	mailboxes, err := s.db.GetDueMailboxes(0)
+	s.NoError(err)
+	s.Len(mailboxes, 1)
+	s.Equal("user@example.com", mailboxes[0].Email)
+}
+
+func (s *DatabaseTestSuite) TestAddMailbox_Invalid() {
+	err := s.db.AddMailbox(context.Background(), "*@example.com")
+	s.ErrorIs(err, ErrInvalidEmail)
+}
+
 func (s *DatabaseTestSuite) TestGetDueMailboxes_Empty() {
 	mailboxes, err := s.db.GetDueMailboxes(24)
 	s.NoError(err)
@@ -59,7 +82,7 @@ func (s *DatabaseTestSuite) TestGetDueMailboxes_Empty() {
 }
 
 func (s *DatabaseTestSuite) TestGetDueMailboxes_NotDue() {
-	err := s.db.AddMailbox("test@example.com")
+	err := s.db.AddMailbox(context.Background(), "test@example.com")
 	s.NoError(err)
 
 	// Mailbox should not be due with 24 hour retention
@@ -69,7 +92,7 @@ func (s *DatabaseTestSuite) TestGetDueMailboxes_NotDue() {
 }
 
 func (s *DatabaseTestSuite) TestGetDueMailboxes_Due() {
-	err := s.db.AddMailbox("test@example.com")
+	err := s.db.AddMailbox(context.Background(), "test@example.com")
 	s.NoError(err)
 
 	// Mailbox should be due with 0 hour retention
@@ -80,7 +103,7 @@ func (s *DatabaseTestSuite) TestGetDueMailboxes_Due() {
 }
 
 func (s *DatabaseTestSuite) TestRemoveMailbox() {
-	err := s.db.AddMailbox("test@example.com")
+	err := s.db.AddMailbox(context.Background(), "test@example.com")
 	s.NoError(err)
 
 	err = s.db.RemoveMailbox("test@example.com")
@@ -92,11 +115,64 @@ func (s *DatabaseTestSuite) TestRemoveMailbox() {
 	s.Empty(mailboxes)
 }
 
+func (s *DatabaseTestSuite) TestRemoveMailbox_Normalized() {
+	err := s.db.AddMailbox(context.Background(), "test@example.com")
+	s.NoError(err)
+
+	err = s.db.RemoveMailbox("Test@EXAMPLE.com")
+	s.NoError(err)
+
+	mailboxes, err := s.db.GetDueMailboxes(0)
+	s.NoError(err)
+	s.Empty(mailboxes)
+}
+
 func (s *DatabaseTestSuite) TestRemoveMailbox_NotExists() {
 	err := s.db.RemoveMailbox("nonexistent@example.com")
 	s.NoError(err) // Should not error, just no-op
 }
 
+func (s *DatabaseTestSuite) TestCancelMailbox() {
+	err := s.db.AddMailbox(context.Background(), "test@example.com")
+	s.NoError(err)
+
+	err = s.db.CancelMailbox("Test@example.com")
+	s.NoError(err)
+
+	mailboxes, err := s.db.GetMailboxes()
+	s.NoError(err)
+	s.Empty(mailboxes)
+
+	err = s.db.CancelMailbox("test@example.com")
+	s.ErrorIs(err, ErrMailboxNotFound)
+}
+
+func (s *DatabaseTestSuite) TestMarkVerificationFailed() {
+	err := s.db.AddMailbox(context.Background(), "test@example.com")
+	s.NoError(err)
+
+	err = s.db.MarkVerificationFailed("test@example.com", "INBOX=3;Sent=1")
+	s.NoError(err)
+
+	mailboxes, err := s.db.GetMailboxes()
+	s.NoError(err)
+	s.Require().Len(mailboxes, 1)
+	s.Equal(MailboxStateVerificationFailed, mailboxes[0].State)
+	s.Equal("INBOX=3;Sent=1", mailboxes[0].Residual)
+}
+
+func (s *DatabaseTestSuite) TestMarkVerificationFailed_NotExists() {
+	err := s.db.MarkVerificationFailed("nonexistent@example.com", "INBOX=3")
+	s.ErrorIs(err, ErrMailboxNotFound)
+}
+
+func (s *DatabaseTestSuite) TestCheck() {
+	s.NoError(s.db.Check())
+
+	s.Require().NoError(os.Remove(s.tempFile))
+	s.Error(s.db.Check())
+}
+
 func TestDatabaseTestSuite(t *testing.T) {
 	suite.Run(t, new(DatabaseTestSuite))
 }
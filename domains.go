@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DomainFilter restricts the janitor to the domains it is responsible for
+type DomainFilter struct {
+	domains map[string]struct{}
+}
+
+// NewDomainFilter creates a domain filter; an empty list allows all domains.
+// Internationalized domains are converted to punycode like email addresses.
+func NewDomainFilter(domains []string) (*DomainFilter, error) {
+	filter := &DomainFilter{domains: make(map[string]struct{})}
+
+	for _, domain := range domains {
+		domain = strings.TrimSpace(domain)
+		if domain == "" {
+			continue
+		}
+
+		normalized, err := normalizeDomain(domain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid domain %q: %w", domain, err)
+		}
+		filter.domains[normalized] = struct{}{}
+	}
+
+	return filter, nil
+}
+
+// Allowed reports whether the domain of the email address is handled by this janitor
+func (f *DomainFilter) Allowed(email string) bool {
+	if f == nil || len(f.domains) == 0 {
+		return true
+	}
+
+	_, ok := f.domains[emailDomain(email)]
+	return ok
+}
+
+// emailDomain returns the lowercased domain part of an email address
+func emailDomain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+
+	return strings.ToLower(email[i+1:])
+}
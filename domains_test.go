@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDomainFilter_Allowed(t *testing.T) {
+	filter, err := NewDomainFilter([]string{"example.org", " Example.NET ", "Bücher.example"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		email   string
+		allowed bool
+	}{
+		{"allowed domain", "user@example.org", true},
+		{"allowed domain case insensitive", "user@EXAMPLE.org", true},
+		{"allowed domain trimmed", "user@example.net", true},
+		{"internationalized domain", "user@xn--bcher-kva.example", true},
+		{"foreign domain", "user@example.com", false},
+		{"subdomain", "user@mail.example.org", false},
+		{"no domain", "user", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, filter.Allowed(tt.email))
+		})
+	}
+}
+
+func TestDomainFilter_Empty(t *testing.T) {
+	filter, err := NewDomainFilter(nil)
+	require.NoError(t, err)
+	assert.True(t, filter.Allowed("user@example.org"))
+
+	filter = nil
+	assert.True(t, filter.Allowed("user@example.org"))
+}
+
+func TestNewDomainFilter_Invalid(t *testing.T) {
+	_, err := NewDomainFilter([]string{"example.org", "exa mple.org"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// Backends for doveadm operations
+const (
+	DoveadmBackendExec = "exec"
+	DoveadmBackendHTTP = "http"
+)
+
+// Doveadm performs doveadm operations for a single user
+type Doveadm interface {
+	// Purge removes the mailbox data of the user
+	Purge(ctx context.Context, email string) error
+	// MailboxStatus returns the message counts of all non-empty mailboxes of the user
+	MailboxStatus(email string) (map[string]int, error)
+	// UserHome returns the home directory of the user
+	UserHome(email string) (string, error)
+}
+
+// HomeChecker checks the home directories of users on the mail storage. It is
+// implemented by clients with access to the storage, like DoveadmExec.
+type HomeChecker interface {
+	// HomeExists reports whether the home directory still exists
+	HomeExists(home string) (bool, error)
+}
+
+// DoveadmExec runs the local doveadm binary, optionally through sudo
+type DoveadmExec struct {
+	path    string
+	useSudo bool
+}
+
+// NewDoveadmExec creates a new doveadm client executing the binary at path
+func NewDoveadmExec(path string, useSudo bool) *DoveadmExec {
+	return &DoveadmExec{
+		path:    path,
+		useSudo: useSudo,
+	}
+}
+
+// Purge executes doveadm purge for the user
+func (d *DoveadmExec) Purge(ctx context.Context, email string) (err error) {
+	span := startDoveadmSpan(ctx, "purge", email, attribute.String("process.executable.path", d.path))
+	defer endSpan(span, &err)
+
+	cmd := newCommand(d.useSudo, d.path, "purge", "-u", email)
+
+	logger.Debug("Executing command",
+		commandField(cmd, email),
+		emailField(email))
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("doveadm purge failed: %w, output: %s", err, string(output))
+	}
+
+	logger.Debug("Command executed successfully",
+		zap.String("output", string(output)),
+		emailField(email))
+
+	return nil
+}
+
+// MailboxStatus executes doveadm mailbox status for all mailboxes of the user
+func (d *DoveadmExec) MailboxStatus(email string) (map[string]int, error) {
+	cmd := newCommand(d.useSudo, d.path, "-f", "flow", "mailbox", "status", "-u", email, "messages", "*")
+
+	logger.Debug("Executing command",
+		commandField(cmd, email),
+		emailField(email))
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("doveadm mailbox status failed: %w, output: %s", err, string(output))
+	}
+
+	return parseMailboxStatus(string(output))
+}
+
+// UserHome executes doveadm user to look up the home directory of the user
+func (d *DoveadmExec) UserHome(email string) (string, error) {
+	cmd := newCommand(d.useSudo, d.path, "user", "-f", "home", email)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("doveadm user failed: %w", err)
+	}
+
+	home := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(home) {
+		return "", fmt.Errorf("invalid home directory %q", home)
+	}
+
+	return home, nil
+}
+
+// String describes the client for logging
+func (d *DoveadmExec) String() string {
+	if d.useSudo {
+		return "exec sudo " + d.path
+	}
+
+	return "exec " + d.path
+}
+
+// Check verifies that the binary is executable and, with sudo, may be run non-interactively
+func (d *DoveadmExec) Check() error {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() || info.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("%s is not executable", d.path)
+	}
+
+	if d.useSudo {
+		// sudo -l exits with a non-zero status if the command is not permitted,
+		// the arguments match sudoers rules restricted to purging
+		output, err := exec.Command("sudo", "-n", "-l", d.path, "purge", "-u", "readiness@example.invalid").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("sudo not permitted: %w, output: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	return nil
+}
+
+// HomeExists checks whether the home directory still exists on the local host
+func (d *DoveadmExec) HomeExists(home string) (bool, error) {
+	// test exits with a non-zero status if the path does not exist
+	cmd := newCommand(d.useSudo, "test", "-e", home)
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return false, nil
+		}
+		return false, fmt.Errorf("home directory check failed: %w", err)
+	}
+
+	return true, nil
+}
+
+// newCommand builds a command, optionally wrapped in sudo
+func newCommand(useSudo bool, name string, args ...string) *exec.Cmd {
+	if useSudo {
+		return exec.Command("sudo", append([]string{name}, args...)...)
+	}
+
+	return exec.Command(name, args...)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// doveadmTag identifies the single command sent in each request
+const doveadmTag = "janitor"
+
+// DoveadmHTTP talks to the doveadm HTTP API of a Dovecot server
+type DoveadmHTTP struct {
+	url      string
+	apiKey   string
+	password string
+	client   *http.Client
+}
+
+// NewDoveadmHTTP creates a new doveadm HTTP API client. The API key takes
+// precedence over the password for basic authentication as user "doveadm".
+func NewDoveadmHTTP(url, apiKey, password string, timeout time.Duration) *DoveadmHTTP {
+	return &DoveadmHTTP{
+		url:      url,
+		apiKey:   apiKey,
+		password: password,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// Purge runs the purge command for the user
+func (d *DoveadmHTTP) Purge(ctx context.Context, email string) (err error) {
+	span := startDoveadmSpan(ctx, "purge", email, attribute.String("url.full", d.url))
+	defer endSpan(span, &err)
+
+	_, err = d.run("purge", map[string]any{"user": email})
+	return err
+}
+
+// MailboxStatus runs the mailboxStatus command for all mailboxes of the user
+func (d *DoveadmHTTP) MailboxStatus(email string) (map[string]int, error) {
+	rows, err := d.run("mailboxStatus", map[string]any{
+		"user":        email,
+		"field":       []string{"messages"},
+		"mailboxMask": []string{"*"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, row := range rows {
+		mailbox, _ := row["mailbox"].(string)
+		count, err := strconv.Atoi(fmt.Sprint(row["messages"]))
+		if err != nil {
+			return nil, fmt.Errorf("unexpected mailbox status %v: %w", row, err)
+		}
+
+		if count > 0 {
+			counts[mailbox] = count
+		}
+	}
+
+	return counts, nil
+}
+
+// UserHome runs the user command to look up the home directory of the user
+func (d *DoveadmHTTP) UserHome(email string) (string, error) {
+	rows, err := d.run("user", map[string]any{
+		"userMask": []string{email},
+		"field":    "home",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(rows) != 1 {
+		return "", fmt.Errorf("unexpected user lookup result %v", rows)
+	}
+
+	// The field is returned under its own name or as the only column
+	home, _ := rows[0]["home"].(string)
+	if home == "" && len(rows[0]) == 1 {
+		for _, value := range rows[0] {
+			home, _ = value.(string)
+		}
+	}
+
+	if !filepath.IsAbs(home) {
+		return "", fmt.Errorf("invalid home directory %q", home)
+	}
+
+	return home, nil
+}
+
+// String describes the client for logging
+func (d *DoveadmHTTP) String() string {
+	return "http " + d.url
+}
+
+// Check verifies that the API responds to an authenticated request for the command list
+func (d *DoveadmHTTP) Check() error {
+	req, err := http.NewRequest(http.MethodGet, d.url, nil)
+	if err != nil {
+		return err
+	}
+	d.authorize(req)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("doveadm API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("doveadm API responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// authorize adds the API key or password to the request
+func (d *DoveadmHTTP) authorize(req *http.Request) {
+	if d.apiKey != "" {
+		req.Header.Set("Authorization", "X-Dovecot-API "+base64.StdEncoding.EncodeToString([]byte(d.apiKey)))
+	} else {
+		req.SetBasicAuth("doveadm", d.password)
+	}
+}
+
+// loggedParameters returns the parameters with the user pseudonymized like in emailField
+func loggedParameters(parameters map[string]any) map[string]any {
+	logged := make(map[string]any, len(parameters))
+	for key, value := range parameters {
+		switch key {
+		case "user":
+			logged[key] = logEmails.pseudonymize(fmt.Sprint(value))
+		case "userMask":
+			var masks []string
+			for _, mask := range value.([]string) {
+				masks = append(masks, logEmails.pseudonymize(mask))
+			}
+			logged[key] = masks
+		default:
+			logged[key] = value
+		}
+	}
+
+	return logged
+}
+
+// run sends a single command to the API and returns the response rows
+func (d *DoveadmHTTP) run(command string, parameters map[string]any) ([]map[string]any, error) {
+	body, err := json.Marshal([][]any{{command, parameters, doveadmTag}})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	d.authorize(req)
+
+	logger.Debug("Sending doveadm API request",
+		zap.String("command", command),
+		zap.Any("parameters", loggedParameters(parameters)))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("doveadm %s request failed: %w", command, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read doveadm %s response: %w", command, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doveadm %s failed with status %d: %s", command, resp.StatusCode, string(respBody))
+	}
+
+	// The response is a list of [type, data, tag] tuples
+	var responses [][]json.RawMessage
+	if err := json.Unmarshal(respBody, &responses); err != nil {
+		return nil, fmt.Errorf("invalid doveadm %s response: %w", command, err)
+	}
+
+	if len(responses) != 1 || len(responses[0]) != 3 {
+		return nil, fmt.Errorf("unexpected doveadm %s response: %s", command, string(respBody))
+	}
+
+	var responseType string
+	if err := json.Unmarshal(responses[0][0], &responseType); err != nil {
+		return nil, fmt.Errorf("invalid doveadm %s response: %w", command, err)
+	}
+
+	switch responseType {
+	case "doveadmResponse":
+		var rows []map[string]any
+		if err := json.Unmarshal(responses[0][1], &rows); err != nil {
+			return nil, fmt.Errorf("invalid doveadm %s response: %w", command, err)
+		}
+		return rows, nil
+	case "error":
+		var data struct {
+			Type     string `json:"type"`
+			ExitCode int    `json:"exitCode"`
+		}
+		_ = json.Unmarshal(responses[0][1], &data)
+		return nil, fmt.Errorf("doveadm %s failed: %s (exit code %d)", command, data.Type, data.ExitCode)
+	default:
+		return nil, errors.New("unexpected doveadm response type " + responseType)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type DoveadmHTTPTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	client   *DoveadmHTTP
+	commands []string
+	// responses maps commands to the response tuple returned by the stand-in
+	responses map[string][]any
+}
+
+func (s *DoveadmHTTPTestSuite) SetupTest() {
+	logger = zap.NewNop()
+
+	s.commands = nil
+	s.responses = map[string][]any{}
+
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := "X-Dovecot-API " + base64.StdEncoding.EncodeToString([]byte("secret-key"))
+		if r.Header.Get("Authorization") != expected {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// The command list is used for readiness checks
+		if r.Method == http.MethodGet {
+			_ = json.NewEncoder(w).Encode([]any{})
+			return
+		}
+
+		var request [][]any
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		command := request[0][0].(string)
+		s.commands = append(s.commands, command)
+
+		response, ok := s.responses[command]
+		if !ok {
+			response = []any{"doveadmResponse", []any{}, request[0][2]}
+		}
+		_ = json.NewEncoder(w).Encode([][]any{response})
+	}))
+
+	s.client = NewDoveadmHTTP(s.server.URL+"/doveadm/v1", "secret-key", "", time.Second)
+}
+
+func (s *DoveadmHTTPTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *DoveadmHTTPTestSuite) TestPurge() {
+	err := s.client.Purge(context.Background(), "user@example.com")
+	s.NoError(err)
+	s.Equal([]string{"purge"}, s.commands)
+}
+
+func (s *DoveadmHTTPTestSuite) TestPurge_Error() {
+	s.responses["purge"] = []any{"error", map[string]any{"type": "exitCode", "exitCode": 67}, doveadmTag}
+
+	err := s.client.Purge(context.Background(), "user@example.com")
+	s.ErrorContains(err, "exit code 67")
+}
+
+func (s *DoveadmHTTPTestSuite) TestPurge_Unauthorized() {
+	client := NewDoveadmHTTP(s.server.URL+"/doveadm/v1", "", "wrong-password", time.Second)
+
+	err := client.Purge(context.Background(), "user@example.com")
+	s.ErrorContains(err, "status 401")
+}
+
+func (s *DoveadmHTTPTestSuite) TestPurge_Unreachable() {
+	s.server.Close()
+
+	err := s.client.Purge(context.Background(), "user@example.com")
+	s.Error(err)
+}
+
+func (s *DoveadmHTTPTestSuite) TestMailboxStatus() {
+	s.responses["mailboxStatus"] = []any{"doveadmResponse", []any{
+		map[string]any{"mailbox": "INBOX", "messages": "3"},
+		map[string]any{"mailbox": "Sent", "messages": "0"},
+		map[string]any{"mailbox": "Trash", "messages": 1},
+	}, doveadmTag}
+
+	counts, err := s.client.MailboxStatus("user@example.com")
+	s.NoError(err)
+	s.Equal(map[string]int{"INBOX": 3, "Trash": 1}, counts)
+}
+
+func (s *DoveadmHTTPTestSuite) TestUserHome() {
+	s.responses["user"] = []any{"doveadmResponse", []any{
+		map[string]any{"home": "/var/vmail/example.com/user"},
+	}, doveadmTag}
+
+	home, err := s.client.UserHome("user@example.com")
+	s.NoError(err)
+	s.Equal("/var/vmail/example.com/user", home)
+}
+
+func (s *DoveadmHTTPTestSuite) TestUserHome_Invalid() {
+	s.responses["user"] = []any{"doveadmResponse", []any{
+		map[string]any{"home": "relative/path"},
+	}, doveadmTag}
+
+	_, err := s.client.UserHome("user@example.com")
+	s.Error(err)
+}
+
+func (s *DoveadmHTTPTestSuite) TestLoggedParameters() {
+	defer func() { logEmails = &emailPseudonymizer{mode: LogEmailsPlain} }()
+	s.Require().NoError(SetLogEmails(LogEmailsMask, ""))
+
+	logged := loggedParameters(map[string]any{"user": "user@example.com", "userMask": []string{"user@example.com"}, "field": "home"})
+	s.Equal(map[string]any{"user": "***@example.com", "userMask": []string{"***@example.com"}, "field": "home"}, logged)
+}
+
+func (s *DoveadmHTTPTestSuite) TestCheck() {
+	s.NoError(s.client.Check())
+
+	s.Error(NewDoveadmHTTP(s.server.URL+"/doveadm/v1", "wrong-key", "", time.Second).Check())
+	s.Error(NewDoveadmHTTP("http://127.0.0.1:1/doveadm/v1", "secret-key", "", time.Second).Check())
+}
+
+func TestDoveadmHTTPTestSuite(t *testing.T) {
+	suite.Run(t, new(DoveadmHTTPTestSuite))
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// WorkerStatus describes the progress of the worker
+type WorkerStatus struct {
+	Running             bool      `json:"running"`
+	Stalled             bool      `json:"stalled"`
+	LastTickStartedAt   time.Time `json:"last_tick_started_at,omitzero"`
+	LastTickFinishedAt  time.Time `json:"last_tick_finished_at,omitzero"`
+	LastTickDuration    float64   `json:"last_tick_duration_seconds"`
+	CurrentEmail        string    `json:"current_email,omitempty"`
+	CurrentStartedAt    time.Time `json:"current_started_at,omitzero"`
+	CurrentDuration     float64   `json:"current_duration_seconds,omitempty"`
+	Ticks               uint64    `json:"ticks"`
+	SlowTicks           uint64    `json:"slow_ticks"`
+	TickIntervalSeconds float64   `json:"tick_interval_seconds"`
+	TickWarnDuration    float64   `json:"tick_warn_duration_seconds"`
+}
+
+// heartbeat records the progress of the worker and warns about slow ticks
+type heartbeat struct {
+	mu           sync.Mutex
+	status       WorkerStatus
+	warnDuration time.Duration
+	warnTimer    *time.Timer
+}
+
+// beginTick records the start of a tick and arms the slow tick warning
+func (h *heartbeat) beginTick() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.status.Running = true
+	h.status.Stalled = false
+	h.status.LastTickStartedAt = time.Now()
+
+	if h.warnDuration > 0 {
+		h.warnTimer = time.AfterFunc(h.warnDuration, h.warnStalled)
+	}
+}
+
+// warnStalled logs a warning for a tick still running after the warn duration
+func (h *heartbeat) warnStalled() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.status.Running {
+		return
+	}
+
+	h.status.Stalled = true
+	fields := []zap.Field{
+		zap.Duration("elapsed", time.Since(h.status.LastTickStartedAt).Round(time.Second)),
+		zap.Duration("warnDuration", h.warnDuration),
+	}
+	if h.status.CurrentEmail != "" {
+		fields = append(fields,
+			emailField(h.status.CurrentEmail),
+			zap.Duration("emailElapsed", time.Since(h.status.CurrentStartedAt).Round(time.Second)))
+	}
+
+	logger.Warn("Worker tick exceeds warn duration, possibly stalled", fields...)
+}
+
+// endTick records the end of a tick
+func (h *heartbeat) endTick() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.warnTimer != nil {
+		h.warnTimer.Stop()
+		h.warnTimer = nil
+	}
+
+	now := time.Now()
+	duration := now.Sub(h.status.LastTickStartedAt)
+
+	h.status.Running = false
+	h.status.Stalled = false
+	h.status.LastTickFinishedAt = now
+	h.status.LastTickDuration = duration.Seconds()
+	h.status.Ticks++
+
+	if h.warnDuration > 0 && duration > h.warnDuration {
+		h.status.SlowTicks++
+		logger.Warn("Worker tick took longer than warn duration",
+			zap.Duration("duration", duration.Round(time.Second)),
+			zap.Duration("warnDuration", h.warnDuration))
+	}
+}
+
+// setCurrent records the mailbox being processed, an empty email clears it
+func (h *heartbeat) setCurrent(email string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.status.CurrentEmail = email
+	h.status.CurrentStartedAt = time.Time{}
+	if email != "" {
+		h.status.CurrentStartedAt = time.Now()
+	}
+}
+
+// Status returns the current progress of the worker
+func (w *Worker) Status() WorkerStatus {
+	w.heartbeat.mu.Lock()
+	defer w.heartbeat.mu.Unlock()
+
+	status := w.heartbeat.status
+	if status.CurrentEmail != "" {
+		status.CurrentDuration = time.Since(status.CurrentStartedAt).Seconds()
+	}
+	status.TickIntervalSeconds = w.tickInterval.Seconds()
+	status.TickWarnDuration = w.heartbeat.warnDuration.Seconds()
+
+	return status
+}
+
+// CheckTick verifies that the running tick is not stalled, or that the last tick
+// finished within the last two intervals if none is running. A long tick is only
+// reported once it exceeds the warn duration, so a long purge is not interrupted.
+func (w *Worker) CheckTick() error {
+	status := w.Status()
+	if status.LastTickStartedAt.IsZero() {
+		return errors.New("worker has not ticked yet")
+	}
+
+	if status.Running {
+		if status.Stalled {
+			return fmt.Errorf("tick running for %s", time.Since(status.LastTickStartedAt).Round(time.Second))
+		}
+		return nil
+	}
+
+	if since := time.Since(status.LastTickFinishedAt); since > 2*w.tickInterval {
+		return fmt.Errorf("last tick finished %s ago", since.Round(time.Second))
+	}
+
+	return nil
+}
+
+// handleWorkerStatus returns the progress of the worker
+func (s *Server) handleWorkerStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.worker.Status())
+}
+
+// TriggerResponse is the response of a manual worker run request
+type TriggerResponse struct {
+	Queued  bool `json:"queued"`
+	Running bool `json:"running"`
+}
+
+// handleTriggerWorker requests an immediate worker run
+func (s *Server) handleTriggerWorker(w http.ResponseWriter, r *http.Request) {
+	queued := s.worker.Trigger("admin API")
+	writeJSON(w, http.StatusAccepted, TriggerResponse{Queued: queued, Running: s.worker.Status().Running})
+}
+
+// handleMetrics exposes worker and server metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	metric := func(name, kind, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+	}
+
+	metric("mailbox_janitor_foreign_domain_events_total", "counter",
+		"Events ignored because of the domain filter.", float64(s.foreignDomainEvents.Load()))
+
+	if s.worker != nil {
+		status := s.worker.Status()
+		metric("mailbox_janitor_worker_last_tick_start_timestamp_seconds", "gauge",
+			"Start of the latest worker tick.", unixSeconds(status.LastTickStartedAt))
+		metric("mailbox_janitor_worker_last_tick_end_timestamp_seconds", "gauge",
+			"End of the latest completed worker tick.", unixSeconds(status.LastTickFinishedAt))
+		metric("mailbox_janitor_worker_last_tick_duration_seconds", "gauge",
+			"Duration of the latest completed worker tick.", status.LastTickDuration)
+		metric("mailbox_janitor_worker_tick_running", "gauge",
+			"Whether a worker tick is running.", boolValue(status.Running))
+		metric("mailbox_janitor_worker_stalled", "gauge",
+			"Whether the running worker tick exceeds the warn duration.", boolValue(status.Stalled))
+		metric("mailbox_janitor_worker_current_mailbox_duration_seconds", "gauge",
+			"Time spent on the mailbox currently being processed.", status.CurrentDuration)
+		metric("mailbox_janitor_worker_ticks_total", "counter",
+			"Completed worker ticks.", float64(status.Ticks))
+		metric("mailbox_janitor_worker_slow_ticks_total", "counter",
+			"Worker ticks that took longer than the warn duration.", float64(status.SlowTicks))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// unixSeconds returns the Unix time in seconds, 0 for the zero time
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return float64(t.UnixNano()) / 1e9
+}
+
+// boolValue returns 1 for true and 0 for false
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
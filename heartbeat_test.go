@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+// blockingPurger blocks purges until released
+type blockingPurger struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingPurger) Purge(ctx context.Context, email string) error {
+	close(p.started)
+	<-p.release
+	return nil
+}
+
+func (p *blockingPurger) Prepare(email string) (string, error) {
+	return "", nil
+}
+
+func (p *blockingPurger) Verify(email, prepared string) (string, error) {
+	return "", nil
+}
+
+func (p *blockingPurger) Describe() string {
+	return "blocking"
+}
+
+type HeartbeatTestSuite struct {
+	suite.Suite
+	db     *Database
+	purger *blockingPurger
+	worker *Worker
+}
+
+func (s *HeartbeatTestSuite) SetupTest() {
+	logger = zap.NewNop()
+
+	tempDir := s.T().TempDir()
+
+	var err error
+	s.db, err = NewDatabase(filepath.Join(tempDir, "mailboxes.csv"), filepath.Join(tempDir, "domain_holds.csv"), NewEmailNormalizer(true))
+	s.Require().NoError(err)
+
+	s.purger = &blockingPurger{started: make(chan struct{}), release: make(chan struct{})}
+	s.worker = NewWorker(s.db, NewPurgers(s.purger, nil), time.Minute, 0, NewEmailNormalizer(true), WorkerOptions{TickWarnDuration: 50 * time.Millisecond})
+}
+
+func (s *HeartbeatTestSuite) TestStalledTick() {
+	s.Require().NoError(s.db.AddMailbox(context.Background(), "test@example.com"))
+
+	done := make(chan struct{})
+	go func() {
+		s.worker.processDueMailboxes()
+		close(done)
+	}()
+
+	<-s.purger.started
+	s.Eventually(func() bool { return s.worker.Status().Stalled }, time.Second, 10*time.Millisecond)
+
+	status := s.worker.Status()
+	s.True(status.Running)
+	s.Equal("test@example.com", status.CurrentEmail)
+	s.Positive(status.CurrentDuration)
+	s.ErrorContains(s.worker.CheckTick(), "tick running for")
+
+	close(s.purger.release)
+	<-done
+
+	status = s.worker.Status()
+	s.False(status.Running)
+	s.False(status.Stalled)
+	s.Empty(status.CurrentEmail)
+	s.Equal(uint64(1), status.Ticks)
+	s.Equal(uint64(1), status.SlowTicks)
+	s.GreaterOrEqual(status.LastTickDuration, 0.05)
+	s.NoError(s.worker.CheckTick())
+}
+
+func (s *HeartbeatTestSuite) TestFastTick() {
+	s.worker.processDueMailboxes()
+
+	status := s.worker.Status()
+	s.Equal(uint64(1), status.Ticks)
+	s.Equal(uint64(0), status.SlowTicks)
+	s.False(status.LastTickFinishedAt.Before(status.LastTickStartedAt))
+	s.Equal(60.0, status.TickIntervalSeconds)
+}
+
+func (s *HeartbeatTestSuite) TestEndpoints() {
+	s.worker.processDueMailboxes()
+
+	server := NewServer("admin-token", s.db, NewEmailNormalizer(true), userliRoutes("test-secret"), ServerOptions{Worker: s.worker})
+	server.RegisterRoutes()
+
+	req := httptest.NewRequest("GET", "/admin/worker", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var status WorkerStatus
+	s.NoError(json.NewDecoder(w.Body).Decode(&status))
+	s.Equal(uint64(1), status.Ticks)
+
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+	s.Contains(w.Body.String(), "# TYPE mailbox_janitor_worker_ticks_total counter\nmailbox_janitor_worker_ticks_total 1\n")
+	s.Contains(w.Body.String(), "mailbox_janitor_worker_stalled 0\n")
+	s.Contains(w.Body.String(), "mailbox_janitor_foreign_domain_events_total 0\n")
+}
+
+func (s *HeartbeatTestSuite) TestTriggerEndpoint() {
+	server := NewServer("admin-token", s.db, NewEmailNormalizer(true), userliRoutes("test-secret"), ServerOptions{Worker: s.worker})
+	server.RegisterRoutes()
+
+	trigger := func() TriggerResponse {
+		req := httptest.NewRequest("POST", "/admin/worker/run", nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		s.Equal(http.StatusAccepted, w.Code)
+
+		var response TriggerResponse
+		s.NoError(json.NewDecoder(w.Body).Decode(&response))
+		return response
+	}
+
+	s.Equal(TriggerResponse{Queued: true}, trigger())
+	s.Equal(TriggerResponse{Queued: false}, trigger())
+}
+
+func TestHeartbeatTestSuite(t *testing.T) {
+	suite.Run(t, new(HeartbeatTestSuite))
+}
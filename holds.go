@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var domainHoldHeader = []string{"domain", "reason", "set_by", "set_at", "expires_at"}
+
+// ErrHoldNotFound is returned when releasing a domain without a legal hold
+var ErrHoldNotFound = errors.New("hold not found")
+
+// Hold is a legal hold preventing mailboxes from being purged
+type Hold struct {
+	Reason    string    `json:"reason"`
+	SetBy     string    `json:"set_by"`
+	SetAt     time.Time `json:"set_at"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// DomainHold is a legal hold on all mailboxes of a domain
+type DomainHold struct {
+	Domain string `json:"domain"`
+	Hold
+}
+
+// Expired reports whether the hold has an expiry that has passed
+func (h *Hold) Expired(now time.Time) bool {
+	return !h.ExpiresAt.IsZero() && !now.Before(h.ExpiresAt)
+}
+
+// end returns the time the hold ends when it is lifted at now
+func (h *Hold) end(now time.Time) time.Time {
+	if h.Expired(now) {
+		return h.ExpiresAt
+	}
+
+	return now
+}
+
+// heldFor returns how long the hold paused the retention of a mailbox queued at createdAt
+func (h *Hold) heldFor(createdAt, now time.Time) time.Duration {
+	if h.SetAt.IsZero() {
+		return 0
+	}
+
+	start := h.SetAt
+	if createdAt.After(start) {
+		start = createdAt
+	}
+
+	if d := h.end(now).Sub(start); d > 0 {
+		return d
+	}
+
+	return 0
+}
+
+// holdToRecord encodes a hold into CSV columns
+func holdToRecord(h *Hold) []string {
+	if h == nil {
+		return []string{"", "", "", ""}
+	}
+
+	var expiresAt string
+	if !h.ExpiresAt.IsZero() {
+		expiresAt = h.ExpiresAt.Format(timeFormat)
+	}
+
+	return []string{h.Reason, h.SetBy, h.SetAt.Format(timeFormat), expiresAt}
+}
+
+// holdFromRecord decodes a hold from CSV columns, returning nil if no hold is set
+func holdFromRecord(record []string) (*Hold, error) {
+	reason, setBy, setAt, expiresAt := field(record, 0), field(record, 1), field(record, 2), field(record, 3)
+	if reason == "" && setBy == "" && setAt == "" && expiresAt == "" {
+		return nil, nil
+	}
+
+	hold := &Hold{Reason: reason, SetBy: setBy}
+
+	var err error
+	if hold.SetAt, err = time.Parse(timeFormat, setAt); err != nil {
+		return nil, fmt.Errorf("invalid set_at: %w", err)
+	}
+
+	if expiresAt != "" {
+		if hold.ExpiresAt, err = time.Parse(timeFormat, expiresAt); err != nil {
+			return nil, fmt.Errorf("invalid expires_at: %w", err)
+		}
+	}
+
+	return hold, nil
+}
+
+// readDomainHolds reads all domain holds from the CSV file
+func (d *Database) readDomainHolds() ([]DomainHold, error) {
+	file, err := os.Open(d.holdsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var holds []DomainHold
+	for i, record := range records {
+		// Skip header
+		if i == 0 || len(record) < 1 || record[0] == "" {
+			continue
+		}
+
+		domain := strings.ToLower(record[0])
+		hold, err := holdFromRecord(record[1:])
+		if err != nil || hold == nil {
+			// Keep the domain on hold rather than risk purging it
+			logger.Warn("Failed to parse domain hold", zap.String("domain", domain), zap.Error(err))
+			hold = &Hold{Reason: field(record, 1)}
+		}
+
+		holds = append(holds, DomainHold{Domain: domain, Hold: *hold})
+	}
+
+	return holds, nil
+}
+
+// writeDomainHolds writes all domain holds to the CSV file
+func (d *Database) writeDomainHolds(holds []DomainHold) error {
+	file, err := os.Create(d.holdsPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+
+	if err := writer.Write(domainHoldHeader); err != nil {
+		return err
+	}
+
+	for _, h := range holds {
+		if err := writer.Write(append([]string{h.Domain}, holdToRecord(&h.Hold)...)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetHolds returns all queued mailboxes under legal hold and all domain holds
+func (d *Database) GetHolds() ([]Mailbox, []DomainHold, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	mailboxes, err := d.readAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read mailboxes: %w", err)
+	}
+
+	domainHolds, err := d.readDomainHolds()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read domain holds: %w", err)
+	}
+
+	var held []Mailbox
+	for _, m := range mailboxes {
+		if m.Hold != nil {
+			held = append(held, m)
+		}
+	}
+
+	return held, domainHolds, nil
+}
+
+// SetMailboxHold places a queued mailbox under legal hold
+func (d *Database) SetMailboxHold(email string, hold Hold) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mailboxes, err := d.readAll()
+	if err != nil {
+		return fmt.Errorf("failed to read mailboxes: %w", err)
+	}
+
+	key := d.normalizer.key(email)
+	found := false
+	for i, m := range mailboxes {
+		if d.normalizer.key(m.Email) != key {
+			continue
+		}
+
+		// Account for the time spent under a previous hold before replacing it
+		if m.Hold != nil {
+			mailboxes[i].HeldFor += m.Hold.heldFor(m.CreatedAt, hold.SetAt)
+		}
+		mailboxes[i].Hold = &hold
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("%w: %s", ErrMailboxNotFound, email)
+	}
+
+	if err := d.writeAll(mailboxes); err != nil {
+		return fmt.Errorf("failed to write mailboxes: %w", err)
+	}
+
+	logger.Info("Legal hold set on mailbox",
+		emailField(email),
+		zap.String("reason", hold.Reason),
+		zap.String("setBy", hold.SetBy),
+		zap.Time("expiresAt", hold.ExpiresAt))
+	return nil
+}
+
+// ReleaseMailboxHold lifts the legal hold of a mailbox, resuming its retention countdown
+func (d *Database) ReleaseMailboxHold(email string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mailboxes, err := d.readAll()
+	if err != nil {
+		return fmt.Errorf("failed to read mailboxes: %w", err)
+	}
+
+	key := d.normalizer.key(email)
+	found := false
+	now := time.Now()
+	for i, m := range mailboxes {
+		if d.normalizer.key(m.Email) != key || m.Hold == nil {
+			continue
+		}
+
+		mailboxes[i].HeldFor += m.Hold.heldFor(m.CreatedAt, now)
+		mailboxes[i].Hold = nil
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("%w: %s", ErrMailboxNotFound, email)
+	}
+
+	if err := d.writeAll(mailboxes); err != nil {
+		return fmt.Errorf("failed to write mailboxes: %w", err)
+	}
+
+	logger.Info("Legal hold released on mailbox", emailField(email))
+	return nil
+}
+
+// SetDomainHold places all mailboxes of a domain under legal hold
+func (d *Database) SetDomainHold(domain string, hold Hold) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	holds, err := d.readDomainHolds()
+	if err != nil {
+		return fmt.Errorf("failed to read domain holds: %w", err)
+	}
+
+	domain = strings.ToLower(domain)
+	var newHolds []DomainHold
+	for _, h := range holds {
+		if h.Domain != domain {
+			newHolds = append(newHolds, h)
+			continue
+		}
+
+		// Keep the original start so that the whole hold period is accounted for
+		hold.SetAt = h.SetAt
+	}
+	newHolds = append(newHolds, DomainHold{Domain: domain, Hold: hold})
+
+	if err := d.writeDomainHolds(newHolds); err != nil {
+		return fmt.Errorf("failed to write domain holds: %w", err)
+	}
+
+	logger.Info("Legal hold set on domain",
+		zap.String("domain", domain),
+		zap.String("reason", hold.Reason),
+		zap.String("setBy", hold.SetBy),
+		zap.Time("expiresAt", hold.ExpiresAt))
+	return nil
+}
+
+// ReleaseDomainHold lifts the legal hold of a domain, resuming the retention countdown of its mailboxes
+func (d *Database) ReleaseDomainHold(domain string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	holds, err := d.readDomainHolds()
+	if err != nil {
+		return fmt.Errorf("failed to read domain holds: %w", err)
+	}
+
+	domain = strings.ToLower(domain)
+	var released *DomainHold
+	var newHolds []DomainHold
+	for _, h := range holds {
+		if h.Domain == domain {
+			released = &h
+			continue
+		}
+		newHolds = append(newHolds, h)
+	}
+
+	if released == nil {
+		return fmt.Errorf("%w: %s", ErrHoldNotFound, domain)
+	}
+
+	if err := d.releaseDomainHolds([]DomainHold{*released}, newHolds, time.Now()); err != nil {
+		return err
+	}
+
+	logger.Info("Legal hold released on domain", zap.String("domain", domain))
+	return nil
+}
+
+// ReleaseExpiredHolds lifts all mailbox and domain holds whose expiry has passed
+func (d *Database) ReleaseExpiredHolds() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+
+	mailboxes, err := d.readAll()
+	if err != nil {
+		return fmt.Errorf("failed to read mailboxes: %w", err)
+	}
+
+	changed := false
+	for i, m := range mailboxes {
+		if m.Hold == nil || !m.Hold.Expired(now) {
+			continue
+		}
+
+		mailboxes[i].HeldFor += m.Hold.heldFor(m.CreatedAt, now)
+		mailboxes[i].Hold = nil
+		changed = true
+		logger.Info("Legal hold expired on mailbox", emailField(m.Email))
+	}
+
+	if changed {
+		if err := d.writeAll(mailboxes); err != nil {
+			return fmt.Errorf("failed to write mailboxes: %w", err)
+		}
+	}
+
+	holds, err := d.readDomainHolds()
+	if err != nil {
+		return fmt.Errorf("failed to read domain holds: %w", err)
+	}
+
+	var expired, remaining []DomainHold
+	for _, h := range holds {
+		if h.Expired(now) {
+			expired = append(expired, h)
+			logger.Info("Legal hold expired on domain", zap.String("domain", h.Domain))
+			continue
+		}
+		remaining = append(remaining, h)
+	}
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	return d.releaseDomainHolds(expired, remaining, now)
+}
+
+// releaseDomainHolds accounts the hold period to all mailboxes of the released
+// domains and stores the remaining holds. Overlapping mailbox and domain holds
+// are both accounted, which only ever extends the retention.
+func (d *Database) releaseDomainHolds(released, remaining []DomainHold, now time.Time) error {
+	mailboxes, err := d.readAll()
+	if err != nil {
+		return fmt.Errorf("failed to read mailboxes: %w", err)
+	}
+
+	for _, h := range released {
+		for i, m := range mailboxes {
+			if emailDomain(m.Email) == h.Domain {
+				mailboxes[i].HeldFor += h.heldFor(m.CreatedAt, now)
+			}
+		}
+	}
+
+	if err := d.writeAll(mailboxes); err != nil {
+		return fmt.Errorf("failed to write mailboxes: %w", err)
+	}
+
+	if err := d.writeDomainHolds(remaining); err != nil {
+		return fmt.Errorf("failed to write domain holds: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type HoldsTestSuite struct {
+	suite.Suite
+	db *Database
+}
+
+func (s *HoldsTestSuite) SetupTest() {
+	logger = zap.NewNop()
+
+	tempDir := s.T().TempDir()
+
+	var err error
+	s.db, err = NewDatabase(filepath.Join(tempDir, "mailboxes.csv"), filepath.Join(tempDir, "domain_holds.csv"), NewEmailNormalizer(true))
+	s.Require().NoError(err)
+}
+
+// addMailboxAt queues a mailbox with a creation time in the past
+func (s *HoldsTestSuite) addMailboxAt(email string, createdAt time.Time) {
+	mailboxes, err := s.db.readAll()
+	s.Require().NoError(err)
+
+	mailboxes = append(mailboxes, Mailbox{Email: email, CreatedAt: createdAt})
+	s.Require().NoError(s.db.writeAll(mailboxes))
+}
+
+func (s *HoldsTestSuite) TestSetMailboxHold() {
+	s.addMailboxAt("held@example.com", time.Now().Add(-48*time.Hour))
+	s.addMailboxAt("other@example.com", time.Now().Add(-48*time.Hour))
+
+	err := s.db.SetMailboxHold("held@example.com", Hold{Reason: "court order", SetBy: "admin", SetAt: time.Now()})
+	s.NoError(err)
+
+	// Held mailbox is not due anymore
+	mailboxes, err := s.db.GetDueMailboxes(24)
+	s.NoError(err)
+	s.Len(mailboxes, 1)
+	s.Equal("other@example.com", mailboxes[0].Email)
+
+	// Held mailbox is listed separately
+	held, domains, err := s.db.GetHolds()
+	s.NoError(err)
+	s.Empty(domains)
+	s.Require().Len(held, 1)
+	s.Equal("held@example.com", held[0].Email)
+	s.Equal("court order", held[0].Hold.Reason)
+	s.Equal("admin", held[0].Hold.SetBy)
+}
+
+func (s *HoldsTestSuite) TestSetMailboxHold_NotFound() {
+	err := s.db.SetMailboxHold("missing@example.com", Hold{Reason: "court order", SetBy: "admin", SetAt: time.Now()})
+	s.ErrorIs(err, ErrMailboxNotFound)
+}
+
+func (s *HoldsTestSuite) TestReleaseMailboxHold_ResumesCountdown() {
+	now := time.Now()
+	s.addMailboxAt("held@example.com", now.Add(-3*time.Hour))
+
+	err := s.db.SetMailboxHold("held@example.com", Hold{Reason: "abuse", SetBy: "admin", SetAt: now.Add(-2 * time.Hour)})
+	s.NoError(err)
+
+	err = s.db.ReleaseMailboxHold("held@example.com")
+	s.NoError(err)
+
+	// Two of the three hours were spent on hold, so it's due after one hour but not after two
+	mailboxes, err := s.db.GetDueMailboxes(2)
+	s.NoError(err)
+	s.Empty(mailboxes)
+
+	mailboxes, err = s.db.GetDueMailboxes(0)
+	s.NoError(err)
+	s.Require().Len(mailboxes, 1)
+	s.Nil(mailboxes[0].Hold)
+	s.InDelta((2 * time.Hour).Seconds(), mailboxes[0].HeldFor.Seconds(), 5)
+}
+
+func (s *HoldsTestSuite) TestReleaseMailboxHold_NotHeld() {
+	s.addMailboxAt("user@example.com", time.Now())
+
+	err := s.db.ReleaseMailboxHold("user@example.com")
+	s.ErrorIs(err, ErrMailboxNotFound)
+}
+
+func (s *HoldsTestSuite) TestReleaseExpiredHolds_Mailbox() {
+	now := time.Now()
+	s.addMailboxAt("held@example.com", now.Add(-3*time.Hour))
+
+	err := s.db.SetMailboxHold("held@example.com", Hold{Reason: "abuse", SetBy: "admin", SetAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	s.NoError(err)
+
+	err = s.db.ReleaseExpiredHolds()
+	s.NoError(err)
+
+	held, _, err := s.db.GetHolds()
+	s.NoError(err)
+	s.Empty(held)
+
+	// Only the hour until the expiry counts as held
+	mailboxes, err := s.db.GetDueMailboxes(0)
+	s.NoError(err)
+	s.Require().Len(mailboxes, 1)
+	s.InDelta(time.Hour.Seconds(), mailboxes[0].HeldFor.Seconds(), 5)
+}
+
+func (s *HoldsTestSuite) TestDomainHold() {
+	now := time.Now()
+	s.addMailboxAt("user@example.com", now.Add(-48*time.Hour))
+	s.addMailboxAt("user@example.org", now.Add(-48*time.Hour))
+
+	err := s.db.SetDomainHold("Example.com", Hold{Reason: "investigation", SetBy: "admin", SetAt: now.Add(-time.Hour)})
+	s.NoError(err)
+
+	mailboxes, err := s.db.GetDueMailboxes(24)
+	s.NoError(err)
+	s.Require().Len(mailboxes, 1)
+	s.Equal("user@example.org", mailboxes[0].Email)
+
+	_, domains, err := s.db.GetHolds()
+	s.NoError(err)
+	s.Require().Len(domains, 1)
+	s.Equal("example.com", domains[0].Domain)
+	s.Equal("investigation", domains[0].Reason)
+
+	err = s.db.ReleaseDomainHold("example.com")
+	s.NoError(err)
+
+	_, domains, err = s.db.GetHolds()
+	s.NoError(err)
+	s.Empty(domains)
+
+	mailboxes, err = s.db.GetDueMailboxes(24)
+	s.NoError(err)
+	s.Len(mailboxes, 2)
+	for _, m := range mailboxes {
+		if m.Email == "user@example.com" {
+			s.InDelta(time.Hour.Seconds(), m.HeldFor.Seconds(), 5)
+		} else {
+			s.Zero(m.HeldFor)
+		}
+	}
+}
+
+func (s *HoldsTestSuite) TestReleaseDomainHold_NotFound() {
+	err := s.db.ReleaseDomainHold("example.com")
+	s.ErrorIs(err, ErrHoldNotFound)
+}
+
+func (s *HoldsTestSuite) TestReleaseExpiredHolds_Domain() {
+	now := time.Now()
+	s.addMailboxAt("user@example.com", now.Add(-48*time.Hour))
+
+	err := s.db.SetDomainHold("example.com", Hold{Reason: "investigation", SetBy: "admin", SetAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	s.NoError(err)
+
+	err = s.db.ReleaseExpiredHolds()
+	s.NoError(err)
+
+	_, domains, err := s.db.GetHolds()
+	s.NoError(err)
+	s.Empty(domains)
+
+	mailboxes, err := s.db.GetDueMailboxes(24)
+	s.NoError(err)
+	s.Require().Len(mailboxes, 1)
+	s.InDelta(time.Hour.Seconds(), mailboxes[0].HeldFor.Seconds(), 5)
+}
+
+func (s *HoldsTestSuite) TestReadAll_InvalidHoldStaysHeld() {
+	content := "email,created_at,held_for,hold_reason,hold_set_by,hold_set_at,hold_expires_at\n" +
+		"user@example.com,2020-01-01T00:00:00Z,,court order,admin,invalid,\n" +
+		"legacy@example.com,2020-01-01T00:00:00Z\n"
+	s.Require().NoError(os.WriteFile(s.db.filePath, []byte(content), 0o600))
+
+	mailboxes, err := s.db.GetDueMailboxes(24)
+	s.NoError(err)
+	s.Require().Len(mailboxes, 1)
+	s.Equal("legacy@example.com", mailboxes[0].Email)
+}
+
+func TestHoldsTestSuite(t *testing.T) {
+	suite.Run(t, new(HoldsTestSuite))
+}
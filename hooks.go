@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Policies for failing pre-purge hooks
+const (
+	HookFailureBlock  = "block"
+	HookFailureIgnore = "ignore"
+)
+
+// Hook stages
+const (
+	hookStagePre  = "pre-purge"
+	hookStagePost = "post-purge"
+)
+
+// maxHookOutput limits the hook output kept for logging
+const maxHookOutput = 4096
+
+// Hooks runs site-specific commands before and after a mailbox is purged
+type Hooks struct {
+	pre           []string
+	post          []string
+	timeout       time.Duration
+	failurePolicy string
+}
+
+// NewHooks creates new purge hooks. Commands are split on whitespace and
+// executed without a shell, the mailbox is passed through the environment.
+func NewHooks(pre, post string, timeout time.Duration, failurePolicy string) *Hooks {
+	return &Hooks{
+		pre:           strings.Fields(pre),
+		post:          strings.Fields(post),
+		timeout:       timeout,
+		failurePolicy: failurePolicy,
+	}
+}
+
+// BeforePurge runs the pre-purge hook and reports whether the purge may proceed
+func (h *Hooks) BeforePurge(email, backend string) bool {
+	if h == nil || len(h.pre) == 0 {
+		return true
+	}
+
+	if err := h.run(hookStagePre, h.pre, email, backend); err != nil {
+		if h.failurePolicy == HookFailureIgnore {
+			logger.Warn("Pre-purge hook failed, purging anyway",
+				emailField(email),
+				zap.Error(err))
+			return true
+		}
+
+		logger.Error("Pre-purge hook failed, skipping purge",
+			emailField(email),
+			zap.Error(err))
+		return false
+	}
+
+	return true
+}
+
+// AfterPurge runs the post-purge hook, failures are only logged
+func (h *Hooks) AfterPurge(email, backend string) {
+	if h == nil || len(h.post) == 0 {
+		return
+	}
+
+	if err := h.run(hookStagePost, h.post, email, backend); err != nil {
+		logger.Error("Post-purge hook failed",
+			emailField(email),
+			zap.Error(err))
+	}
+}
+
+// run executes a hook command with the mailbox in its environment
+func (h *Hooks) run(stage string, command []string, email, backend string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	localPart, domain, _ := strings.Cut(email, "@")
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"JANITOR_HOOK="+stage,
+		"JANITOR_EMAIL="+email,
+		"JANITOR_LOCAL_PART="+localPart,
+		"JANITOR_DOMAIN="+domain,
+		"JANITOR_BACKEND="+backend,
+	)
+	// Do not wait forever for children keeping the output open
+	cmd.WaitDelay = time.Second
+
+	logger.Debug("Executing hook",
+		zap.String("stage", stage),
+		commandField(cmd, email),
+		emailField(email))
+
+	output, err := cmd.CombinedOutput()
+	if len(output) > maxHookOutput {
+		output = output[:maxHookOutput]
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s hook timed out after %s, output: %s", stage, h.timeout, string(output))
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %w, output: %s", stage, err, string(output))
+	}
+
+	logger.Debug("Hook executed successfully",
+		zap.String("stage", stage),
+		zap.String("output", string(output)),
+		emailField(email))
+
+	return nil
+}
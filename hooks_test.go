@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type HooksTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (s *HooksTestSuite) SetupTest() {
+	logger = zap.NewNop()
+	s.dir = s.T().TempDir()
+}
+
+// script writes an executable shell script and returns its path
+func (s *HooksTestSuite) script(name, body string) string {
+	path := filepath.Join(s.dir, name)
+	s.Require().NoError(os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o700))
+	return path
+}
+
+func (s *HooksTestSuite) TestNilHooks() {
+	var hooks *Hooks
+
+	s.True(hooks.BeforePurge("test@example.com", "fake"))
+	hooks.AfterPurge("test@example.com", "fake")
+}
+
+func (s *HooksTestSuite) TestEnvironment() {
+	out := filepath.Join(s.dir, "env")
+	hook := s.script("hook", `env | grep ^JANITOR_ | sort > "$1"`)
+	hooks := NewHooks(hook+" "+out, "", time.Second, HookFailureBlock)
+
+	s.True(hooks.BeforePurge("test@example.com", "fake"))
+
+	env, err := os.ReadFile(out)
+	s.Require().NoError(err)
+	s.Equal([]string{
+		"JANITOR_BACKEND=fake",
+		"JANITOR_DOMAIN=example.com",
+		"JANITOR_EMAIL=test@example.com",
+		"JANITOR_HOOK=pre-purge",
+		"JANITOR_LOCAL_PART=test",
+	}, strings.Fields(string(env)))
+}
+
+func (s *HooksTestSuite) TestNoShellInterpolation() {
+	out := filepath.Join(s.dir, "args")
+	hook := s.script("hook", `echo "$#" > "$1"`)
+	hooks := NewHooks("", hook+" "+out+" $JANITOR_EMAIL;true", time.Second, HookFailureBlock)
+
+	hooks.AfterPurge("test@example.com", "fake")
+
+	args, err := os.ReadFile(out)
+	s.Require().NoError(err)
+	s.Equal("2\n", string(args))
+}
+
+func (s *HooksTestSuite) TestRun_LogsPseudonymizedCommand() {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger = zap.New(core)
+	s.Require().NoError(SetLogEmails(LogEmailsMask, ""))
+	defer func() { logEmails = &emailPseudonymizer{mode: LogEmailsPlain} }()
+
+	hook := s.script("hook", "true")
+	hooks := NewHooks(hook+" test@example.com", "", time.Second, HookFailureBlock)
+
+	s.True(hooks.BeforePurge("test@example.com", "fake"))
+
+	entries := logs.FilterMessage("Executing hook").All()
+	s.Require().Len(entries, 1)
+	s.Equal(hook+" ***@example.com", entries[0].ContextMap()["command"])
+}
+
+func (s *HooksTestSuite) TestBeforePurge_Fails() {
+	hook := s.script("hook", "echo failed; exit 1")
+
+	s.False(NewHooks(hook, "", time.Second, HookFailureBlock).BeforePurge("test@example.com", "fake"))
+	s.True(NewHooks(hook, "", time.Second, HookFailureIgnore).BeforePurge("test@example.com", "fake"))
+}
+
+func (s *HooksTestSuite) TestBeforePurge_Timeout() {
+	hook := s.script("hook", "sleep 5")
+	hooks := NewHooks(hook, "", 100*time.Millisecond, HookFailureBlock)
+
+	start := time.Now()
+	s.False(hooks.BeforePurge("test@example.com", "fake"))
+	s.Less(time.Since(start), 3*time.Second)
+}
+
+func (s *HooksTestSuite) TestRun_NotFound() {
+	hooks := NewHooks("/nonexistent/hook", "", time.Second, HookFailureBlock)
+
+	s.Error(hooks.run(hookStagePre, hooks.pre, "test@example.com", "fake"))
+}
+
+func TestHooksTestSuite(t *testing.T) {
+	suite.Run(t, new(HooksTestSuite))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// unixSocketPrefix marks a listen address as a Unix domain socket path
+const unixSocketPrefix = "unix:"
+
+// sdListenFdsStart is the first file descriptor passed by systemd socket activation
+const sdListenFdsStart = 3
+
+// Listen returns the listener for the HTTP server. A socket passed by systemd socket
+// activation takes precedence over addr, which is either a TCP address or unix:/path.
+func Listen(addr string, socketMode os.FileMode, socketOwner string) (net.Listener, error) {
+	listener, err := activationListener()
+	if listener != nil || err != nil {
+		return listener, err
+	}
+
+	if path, ok := strings.CutPrefix(addr, unixSocketPrefix); ok {
+		return listenUnix(path, socketMode, socketOwner)
+	}
+
+	return net.Listen("tcp", addr)
+}
+
+// activationListener returns the socket passed by systemd, nil if there is none
+func activationListener() (net.Listener, error) {
+	fds := os.Getenv("LISTEN_FDS")
+	if fds == "" {
+		return nil, nil
+	}
+
+	// The sockets were passed to another process, e.g. a wrapper script
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	if n != 1 {
+		return nil, fmt.Errorf("expected exactly one socket from systemd, got %d", n)
+	}
+
+	// Child processes like hooks must not inherit the activation
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	syscall.CloseOnExec(sdListenFdsStart)
+	file := os.NewFile(sdListenFdsStart, "systemd-socket")
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use socket from systemd: %w", err)
+	}
+
+	return listener, nil
+}
+
+// listenUnix listens on a Unix domain socket with the given mode and owner
+func listenUnix(path string, mode os.FileMode, owner string) (net.Listener, error) {
+	uid, gid, err := lookupOwner(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove a stale socket of a previous run, but never a live socket or another file
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+
+		if conn, err := net.Dial("unix", path); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener, nil
+}
+
+// lookupOwner resolves user, user:group or :group to ids, -1 keeps the current value
+func lookupOwner(owner string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner == "" {
+		return uid, gid, nil
+	}
+
+	userName, groupName, _ := strings.Cut(owner, ":")
+	if userName == "" && groupName == "" {
+		return 0, 0, errors.New("empty socket owner")
+	}
+
+	if userName != "" {
+		id := userName
+		if u, err := user.Lookup(userName); err == nil {
+			id = u.Uid
+		}
+
+		var err error
+		if uid, err = strconv.Atoi(id); err != nil {
+			return 0, 0, fmt.Errorf("unknown user %q", userName)
+		}
+	}
+
+	if groupName != "" {
+		id := groupName
+		if g, err := user.LookupGroup(groupName); err == nil {
+			id = g.Gid
+		}
+
+		var err error
+		if gid, err = strconv.Atoi(id); err != nil {
+			return 0, 0, fmt.Errorf("unknown group %q", groupName)
+		}
+	}
+
+	return uid, gid, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type ListenTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (s *ListenTestSuite) SetupTest() {
+	logger = zap.NewNop()
+
+	// Socket paths are limited to about 100 bytes, the test temp dir may be too long
+	dir, err := os.MkdirTemp("", "janitor")
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { _ = os.RemoveAll(dir) })
+	s.dir = dir
+
+	s.T().Setenv("LISTEN_FDS", "")
+	s.T().Setenv("LISTEN_PID", "")
+}
+
+func (s *ListenTestSuite) TestListen_TCP() {
+	listener, err := Listen("127.0.0.1:0", 0660, "")
+	s.Require().NoError(err)
+	defer listener.Close()
+
+	s.Equal("tcp", listener.Addr().Network())
+}
+
+func (s *ListenTestSuite) TestListen_UnixSocket() {
+	path := filepath.Join(s.dir, "janitor.sock")
+
+	listener, err := Listen("unix:"+path, 0600, strconv.Itoa(os.Getuid())+":"+strconv.Itoa(os.Getgid()))
+	s.Require().NoError(err)
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	s.Require().NoError(err)
+	s.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	server := NewServer("", nil, NewEmailNormalizer(true), userliRoutes("test-secret"), ServerOptions{})
+	go func() { _ = server.Start(listener, nil) }()
+
+	transport, err := clientTransport(path, "", "", "")
+	s.Require().NoError(err)
+	resp, err := (&http.Client{Transport: transport}).Get("http://localhost/health")
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("OK", string(body))
+}
+
+func (s *ListenTestSuite) TestListen_ReplacesStaleSocket() {
+	path := filepath.Join(s.dir, "janitor.sock")
+
+	// A socket file left behind by a crashed process
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	s.Require().NoError(err)
+	stale.SetUnlinkOnClose(false)
+	s.Require().NoError(stale.Close())
+
+	listener, err := Listen("unix:"+path, 0660, "")
+	s.Require().NoError(err)
+	s.NoError(listener.Close())
+}
+
+func (s *ListenTestSuite) TestListen_RefusesLiveSocketAndOtherFiles() {
+	path := filepath.Join(s.dir, "janitor.sock")
+
+	live, err := Listen("unix:"+path, 0660, "")
+	s.Require().NoError(err)
+	defer live.Close()
+
+	_, err = Listen("unix:"+path, 0660, "")
+	s.ErrorContains(err, "in use")
+
+	file := filepath.Join(s.dir, "file")
+	s.Require().NoError(os.WriteFile(file, []byte("data"), 0600))
+
+	_, err = Listen("unix:"+file, 0660, "")
+	s.ErrorContains(err, "not a socket")
+
+	data, err := os.ReadFile(file)
+	s.NoError(err)
+	s.Equal("data", string(data))
+}
+
+func (s *ListenTestSuite) TestListen_InvalidOwner() {
+	_, err := Listen("unix:"+filepath.Join(s.dir, "janitor.sock"), 0660, "no-such-user-janitor")
+	s.ErrorContains(err, "unknown user")
+
+	_, err = Listen("unix:"+filepath.Join(s.dir, "janitor.sock"), 0660, ":no-such-group-janitor")
+	s.ErrorContains(err, "unknown group")
+}
+
+func (s *ListenTestSuite) TestLookupOwner() {
+	uid, gid, err := lookupOwner("")
+	s.NoError(err)
+	s.Equal(-1, uid)
+	s.Equal(-1, gid)
+
+	uid, gid, err = lookupOwner("1000")
+	s.NoError(err)
+	s.Equal(1000, uid)
+	s.Equal(-1, gid)
+
+	uid, gid, err = lookupOwner(":1001")
+	s.NoError(err)
+	s.Equal(-1, uid)
+	s.Equal(1001, gid)
+
+	uid, gid, err = lookupOwner("root:root")
+	s.NoError(err)
+	s.Equal(0, uid)
+	s.Equal(0, gid)
+}
+
+func (s *ListenTestSuite) TestActivationListener() {
+	// Sockets passed to another process are ignored
+	s.T().Setenv("LISTEN_FDS", "1")
+	s.T().Setenv("LISTEN_PID", "1")
+
+	listener, err := Listen("127.0.0.1:0", 0660, "")
+	s.Require().NoError(err)
+	defer listener.Close()
+	s.Equal("tcp", listener.Addr().Network())
+
+	s.T().Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	s.T().Setenv("LISTEN_FDS", "2")
+	_, err = Listen("127.0.0.1:0", 0660, "")
+	s.ErrorContains(err, "exactly one socket")
+
+	s.T().Setenv("LISTEN_FDS", "many")
+	_, err = Listen("127.0.0.1:0", 0660, "")
+	s.ErrorContains(err, "invalid LISTEN_FDS")
+}
+
+func TestListenTestSuite(t *testing.T) {
+	suite.Run(t, new(ListenTestSuite))
+}
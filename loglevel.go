@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logLevel is the level of the global logger, changeable at runtime
+var logLevel = zap.NewAtomicLevel()
+
+var (
+	baseLogLevelMu sync.Mutex
+	// baseLogLevel is the configured level, restored when debug logging is toggled off
+	baseLogLevel = zapcore.InfoLevel
+)
+
+// LogLevelRequest is the request body for changing the log level
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// LogLevelResponse is the current log level
+type LogLevelResponse struct {
+	Level string `json:"level"`
+}
+
+// parseLogLevel parses one of debug, info, warn and error. The levels above
+// would silence the errors, as only panics and fatal exits are logged with them.
+func parseLogLevel(level string) (zapcore.Level, error) {
+	parsed, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return parsed, err
+	}
+	if parsed > zapcore.ErrorLevel {
+		return parsed, fmt.Errorf("log level %q is above error", level)
+	}
+
+	return parsed, nil
+}
+
+// InitLogLevel applies the configured log level
+func InitLogLevel(level string) error {
+	parsed, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+
+	baseLogLevelMu.Lock()
+	baseLogLevel = parsed
+	baseLogLevelMu.Unlock()
+
+	logLevel.SetLevel(parsed)
+	return nil
+}
+
+// setLogLevel changes the log level. The change is logged before raising or after
+// lowering the level, so it is visible with both levels.
+func setLogLevel(log *zap.Logger, level zapcore.Level, source string) {
+	previous := logLevel.Level()
+	fields := []zap.Field{zap.Stringer("from", previous), zap.Stringer("to", level), zap.String("source", source)}
+
+	if level < previous {
+		logLevel.SetLevel(level)
+		log.Info("Log level changed", fields...)
+		return
+	}
+
+	log.Info("Log level changed", fields...)
+	logLevel.SetLevel(level)
+}
+
+// ToggleDebugLogging switches between debug and the configured log level
+func ToggleDebugLogging(source string) zapcore.Level {
+	baseLogLevelMu.Lock()
+	next := baseLogLevel
+	baseLogLevelMu.Unlock()
+
+	if logLevel.Level() != zapcore.DebugLevel {
+		next = zapcore.DebugLevel
+	} else if next == zapcore.DebugLevel {
+		next = zapcore.InfoLevel
+	}
+
+	setLogLevel(logger, next, source)
+	return next
+}
+
+// handleGetLogLevel returns the current log level
+func (s *Server) handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, LogLevelResponse{Level: logLevel.Level().String()})
+}
+
+// handleSetLogLevel changes the log level until the next restart or change
+func (s *Server) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req LogLevelRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	level, err := parseLogLevel(req.Level)
+	if err != nil {
+		http.Error(w, "level must be one of debug, info, warn, error", http.StatusBadRequest)
+		return
+	}
+
+	setLogLevel(requestLogger(r.Context()), level, "admin API")
+	writeJSON(w, http.StatusOK, LogLevelResponse{Level: level.String()})
+}
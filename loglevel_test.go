@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestInitLogLevel(t *testing.T) {
+	defer func() { _ = InitLogLevel("info") }()
+
+	assert.Error(t, InitLogLevel("verbose"))
+	assert.Error(t, InitLogLevel("fatal"))
+	assert.Equal(t, zapcore.InfoLevel, logLevel.Level())
+
+	require.NoError(t, InitLogLevel("warn"))
+	assert.Equal(t, zapcore.WarnLevel, logLevel.Level())
+}
+
+func TestToggleDebugLogging(t *testing.T) {
+	core, logs := observer.New(logLevel)
+	logger = zap.New(core)
+	defer func() {
+		logger = zap.NewNop()
+		_ = InitLogLevel("info")
+	}()
+
+	require.NoError(t, InitLogLevel("warn"))
+	logger.Debug("hidden")
+
+	assert.Equal(t, zapcore.DebugLevel, ToggleDebugLogging("SIGUSR2"))
+	logger.Debug("visible")
+
+	assert.Equal(t, zapcore.WarnLevel, ToggleDebugLogging("SIGUSR2"))
+	logger.Debug("hidden again")
+
+	messages := []string{}
+	for _, entry := range logs.All() {
+		messages = append(messages, entry.Message)
+	}
+	assert.Equal(t, []string{"Log level changed", "visible", "Log level changed"}, messages)
+	assert.Equal(t, "SIGUSR2", logs.All()[0].ContextMap()["source"])
+
+	// A configured debug level toggles to info
+	require.NoError(t, InitLogLevel("debug"))
+	assert.Equal(t, zapcore.InfoLevel, ToggleDebugLogging("SIGUSR2"))
+	assert.Equal(t, zapcore.DebugLevel, ToggleDebugLogging("SIGUSR2"))
+}
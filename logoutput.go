@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/syslog"
+	"math"
+	"net"
+	"net/url"
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Supported log formats
+const (
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+)
+
+// Supported log outputs
+const (
+	LogOutputStdout   = "stdout"
+	LogOutputStderr   = "stderr"
+	LogOutputFile     = "file"
+	LogOutputSyslog   = "syslog"
+	LogOutputJournald = "journald"
+)
+
+// logIdentifier names the process in syslog and the journal
+const logIdentifier = "userli-mailbox-janitor"
+
+// journalSocket is the socket of the native journald protocol
+var journalSocket = "/run/systemd/journal/socket"
+
+// NewLogger creates a logger with the configured format and output, using the
+// log level that can be changed at runtime
+func NewLogger(config *Config) (*zap.Logger, error) {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	if config.LogOutput == LogOutputSyslog || config.LogOutput == LogOutputJournald {
+		// Both add their own timestamp
+		encoderConfig.TimeKey = ""
+	}
+
+	var encoder zapcore.Encoder
+	switch config.LogFormat {
+	case LogFormatJSON:
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case LogFormatConsole:
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", config.LogFormat)
+	}
+
+	var core zapcore.Core
+	switch config.LogOutput {
+	case LogOutputStdout:
+		core = zapcore.NewCore(encoder, zapcore.Lock(os.Stdout), logLevel)
+	case LogOutputStderr:
+		core = zapcore.NewCore(encoder, zapcore.Lock(os.Stderr), logLevel)
+	case LogOutputFile:
+		if config.LogFile == "" {
+			return nil, fmt.Errorf("log file is required")
+		}
+		core = zapcore.NewCore(encoder, zapcore.AddSync(&lumberjack.Logger{
+			Filename:   config.LogFile,
+			MaxSize:    config.LogFileMaxSize,
+			MaxBackups: config.LogFileMaxBackups,
+			MaxAge:     int(math.Ceil(config.LogFileMaxAge.Hours() / 24)),
+			Compress:   config.LogFileCompress,
+		}), logLevel)
+	case LogOutputSyslog:
+		w, err := newSyslogWriter(config.LogSyslogAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		core = &priorityCore{LevelEnabler: logLevel, encoder: encoder, out: w}
+	case LogOutputJournald:
+		w, err := newJournalWriter(journalSocket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to journald: %w", err)
+		}
+		core = &priorityCore{LevelEnabler: logLevel, encoder: encoder, out: w}
+	default:
+		return nil, fmt.Errorf("unknown log output %q", config.LogOutput)
+	}
+
+	return zap.New(core), nil
+}
+
+// priorityWriter writes log entries with the priority of their level
+type priorityWriter interface {
+	WriteLevel(level zapcore.Level, p []byte) error
+	Sync() error
+}
+
+// priorityCore passes the level of each entry to the writer, unlike the cores of
+// zap writing to a plain io.Writer
+type priorityCore struct {
+	zapcore.LevelEnabler
+	encoder zapcore.Encoder
+	out     priorityWriter
+}
+
+func (c *priorityCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &priorityCore{LevelEnabler: c.LevelEnabler, encoder: c.encoder.Clone(), out: c.out}
+	for _, field := range fields {
+		field.AddTo(clone.encoder)
+	}
+
+	return clone
+}
+
+func (c *priorityCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+
+	return checked
+}
+
+func (c *priorityCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	return c.out.WriteLevel(entry.Level, bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+func (c *priorityCore) Sync() error {
+	return c.out.Sync()
+}
+
+// syslogPriority maps a zap level to a syslog severity
+func syslogPriority(level zapcore.Level) syslog.Priority {
+	switch level {
+	case zapcore.DebugLevel:
+		return syslog.LOG_DEBUG
+	case zapcore.InfoLevel:
+		return syslog.LOG_INFO
+	case zapcore.WarnLevel:
+		return syslog.LOG_WARNING
+	case zapcore.ErrorLevel:
+		return syslog.LOG_ERR
+	default:
+		return syslog.LOG_CRIT
+	}
+}
+
+// syslogWriter writes to the local syslog daemon or a remote one
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+// newSyslogWriter connects to the local syslog daemon, or to a remote one with an
+// address like udp://logs.example.org:514
+func newSyslogWriter(addr string) (*syslogWriter, error) {
+	if addr == "" {
+		w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, logIdentifier)
+		if err != nil {
+			return nil, err
+		}
+		return &syslogWriter{w: w}, nil
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+		return nil, fmt.Errorf("invalid syslog address %q", addr)
+	}
+
+	w, err := syslog.Dial(u.Scheme, u.Host, syslog.LOG_DAEMON|syslog.LOG_INFO, logIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syslogWriter{w: w}, nil
+}
+
+func (s *syslogWriter) WriteLevel(level zapcore.Level, p []byte) error {
+	message := string(p)
+	switch syslogPriority(level) {
+	case syslog.LOG_DEBUG:
+		return s.w.Debug(message)
+	case syslog.LOG_INFO:
+		return s.w.Info(message)
+	case syslog.LOG_WARNING:
+		return s.w.Warning(message)
+	case syslog.LOG_ERR:
+		return s.w.Err(message)
+	default:
+		return s.w.Crit(message)
+	}
+}
+
+func (s *syslogWriter) Sync() error {
+	return nil
+}
+
+// journalWriter sends entries to journald using its native protocol
+type journalWriter struct {
+	conn *net.UnixConn
+}
+
+// newJournalWriter connects to the journald socket
+func newJournalWriter(socket string) (*journalWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &journalWriter{conn: conn}, nil
+}
+
+func (j *journalWriter) WriteLevel(level zapcore.Level, p []byte) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "PRIORITY=%d\nSYSLOG_IDENTIFIER=%s\n", syslogPriority(level), logIdentifier)
+
+	// The message is sent length-prefixed, as it may contain newlines
+	b.WriteString("MESSAGE\n")
+	_ = binary.Write(&b, binary.LittleEndian, uint64(len(p)))
+	b.Write(p)
+	b.WriteByte('\n')
+
+	_, err := j.conn.Write(b.Bytes())
+	return err
+}
+
+func (j *journalWriter) Sync() error {
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNewLogger_File(t *testing.T) {
+	for _, format := range []string{LogFormatJSON, LogFormatConsole} {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "janitor.log")
+			l, err := NewLogger(&Config{LogFormat: format, LogOutput: LogOutputFile, LogFile: path, LogFileMaxSize: 1})
+			require.NoError(t, err)
+
+			l.Info("Mailbox purged successfully", zap.String("backend", "doveadm"))
+			l.Debug("Not logged at info level")
+
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			require.Len(t, lines, 1)
+
+			if format == LogFormatJSON {
+				var entry map[string]any
+				require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+				assert.Equal(t, "info", entry["level"])
+				assert.Equal(t, "Mailbox purged successfully", entry["msg"])
+				assert.Equal(t, "doveadm", entry["backend"])
+			} else {
+				assert.Contains(t, lines[0], "\tINFO\tMailbox purged successfully\t")
+				assert.Contains(t, lines[0], `{"backend": "doveadm"}`)
+			}
+		})
+	}
+}
+
+func TestNewLogger_Invalid(t *testing.T) {
+	for _, config := range []*Config{
+		{LogFormat: "xml", LogOutput: LogOutputStdout},
+		{LogFormat: LogFormatJSON, LogOutput: "kafka"},
+		{LogFormat: LogFormatJSON, LogOutput: LogOutputFile},
+		{LogFormat: LogFormatJSON, LogOutput: LogOutputSyslog, LogSyslogAddr: "logs.example.org:514"},
+	} {
+		_, err := NewLogger(config)
+		assert.Error(t, err)
+	}
+}
+
+func TestNewLogger_Journald(t *testing.T) {
+	dir, err := os.MkdirTemp("", "janitor")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	previous := journalSocket
+	journalSocket = socket
+	defer func() { journalSocket = previous }()
+
+	l, err := NewLogger(&Config{LogFormat: LogFormatConsole, LogOutput: LogOutputJournald})
+	require.NoError(t, err)
+
+	l.With(zap.String("requestID", "abc")).Warn("Purge failed\nwith details")
+
+	buf := make([]byte, 4096)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+
+	fields, message, ok := bytes.Cut(buf[:n], []byte("MESSAGE\n"))
+	require.True(t, ok)
+	assert.Equal(t, "PRIORITY=4\nSYSLOG_IDENTIFIER=userli-mailbox-janitor\n", string(fields))
+
+	size := binary.LittleEndian.Uint64(message[:8])
+	message = message[8:]
+	require.Equal(t, int(size)+1, len(message))
+	assert.Equal(t, "WARN\tPurge failed\nwith details\t{\"requestID\": \"abc\"}", string(message[:size]))
+}
+
+func TestNewLogger_Syslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	l, err := NewLogger(&Config{LogFormat: LogFormatJSON, LogOutput: LogOutputSyslog, LogSyslogAddr: "udp://" + conn.LocalAddr().String()})
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		log      func(string, ...zap.Field)
+		priority string
+	}{
+		{l.Info, "<30>"},
+		{l.Warn, "<28>"},
+		{l.Error, "<27>"},
+	} {
+		tt.log("Mailbox purged successfully")
+
+		buf := make([]byte, 4096)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+
+		line := string(buf[:n])
+		assert.True(t, strings.HasPrefix(line, tt.priority), line)
+		assert.Contains(t, line, "userli-mailbox-janitor[")
+		assert.Contains(t, line, `"msg":"Mailbox purged successfully"`)
+		assert.NotContains(t, line, `"ts"`)
+	}
+}
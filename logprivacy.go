@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Modes for email addresses in log lines
+const (
+	// LogEmailsPlain logs email addresses as they are
+	LogEmailsPlain = "plain"
+	// LogEmailsHash replaces email addresses with a keyed hash
+	LogEmailsHash = "hash"
+	// LogEmailsMask replaces the local part of email addresses with ***
+	LogEmailsMask = "mask"
+)
+
+// emailPseudonymizer replaces email addresses in log lines
+type emailPseudonymizer struct {
+	mode string
+	key  []byte
+}
+
+// logEmails is applied to all email addresses passed to emailField
+var logEmails = &emailPseudonymizer{mode: LogEmailsPlain}
+
+// SetLogEmails configures how email addresses are logged. The hash mode
+// requires a key, so hashes of known addresses cannot be computed without it.
+func SetLogEmails(mode, key string) error {
+	switch mode {
+	case LogEmailsPlain, LogEmailsMask:
+	case LogEmailsHash:
+		if key == "" {
+			return errors.New("hash mode requires a key")
+		}
+	default:
+		return fmt.Errorf("unknown mode %q", mode)
+	}
+
+	logEmails = &emailPseudonymizer{mode: mode, key: []byte(key)}
+	return nil
+}
+
+// pseudonymize returns the email address as it should appear in logs
+func (p *emailPseudonymizer) pseudonymize(email string) string {
+	switch p.mode {
+	case LogEmailsHash:
+		// Stable for the same key, so log lines of one mailbox can be correlated
+		mac := hmac.New(sha256.New, p.key)
+		mac.Write([]byte(strings.ToLower(email)))
+		return hex.EncodeToString(mac.Sum(nil)[:8])
+	case LogEmailsMask:
+		if i := strings.LastIndex(email, "@"); i >= 0 {
+			return "***" + email[i:]
+		}
+		return "***"
+	default:
+		return email
+	}
+}
+
+// emailField returns the email address as log field, pseudonymized according to LOG_EMAILS
+func emailField(email string) zap.Field {
+	return zap.String("email", logEmails.pseudonymize(email))
+}
+
+// commandField returns the command line as log field. Unless email addresses are
+// logged as they are, the email address argument is pseudonymized and the paths
+// of the mailbox, which usually contain it, are replaced with ***.
+func commandField(cmd *exec.Cmd, email string, paths ...string) zap.Field {
+	args := append([]string{cmd.Path}, cmd.Args[1:]...)
+	if logEmails.mode != LogEmailsPlain {
+		for i, arg := range args {
+			if email != "" && arg == email {
+				args[i] = logEmails.pseudonymize(email)
+			} else if slices.Contains(paths, arg) {
+				args[i] = "***"
+			}
+		}
+	}
+
+	return zap.String("command", strings.Join(args, " "))
+}
+
+// pathField returns a path of a mailbox as log field. It usually contains the
+// email address, so it is omitted unless email addresses are logged as they are.
+func pathField(key, path string) zap.Field {
+	if logEmails.mode != LogEmailsPlain {
+		return zap.Skip()
+	}
+
+	return zap.String(key, path)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestEmailPseudonymizer(t *testing.T) {
+	plain := &emailPseudonymizer{mode: LogEmailsPlain}
+	assert.Equal(t, "user@example.org", plain.pseudonymize("user@example.org"))
+
+	mask := &emailPseudonymizer{mode: LogEmailsMask}
+	assert.Equal(t, "***@example.org", mask.pseudonymize("user@example.org"))
+	assert.Equal(t, "***@example.org", mask.pseudonymize("a@b@example.org"))
+	assert.Equal(t, "***", mask.pseudonymize("invalid"))
+
+	hash := &emailPseudonymizer{mode: LogEmailsHash, key: []byte("key")}
+	hashed := hash.pseudonymize("user@example.org")
+	// Matches the openssl command in the README
+	assert.Equal(t, "1ce91c38836bb756", hashed)
+	// Stable, so log lines of one mailbox can be correlated
+	assert.Equal(t, hashed, hash.pseudonymize("user@example.org"))
+	assert.Equal(t, hashed, hash.pseudonymize("User@Example.org"))
+	assert.NotEqual(t, hashed, hash.pseudonymize("other@example.org"))
+
+	other := &emailPseudonymizer{mode: LogEmailsHash, key: []byte("other")}
+	assert.NotEqual(t, hashed, other.pseudonymize("user@example.org"))
+}
+
+func TestSetLogEmails(t *testing.T) {
+	defer func() { logEmails = &emailPseudonymizer{mode: LogEmailsPlain} }()
+
+	assert.Error(t, SetLogEmails("md5", ""))
+	assert.Error(t, SetLogEmails(LogEmailsHash, ""))
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	log := zap.New(core)
+
+	require.NoError(t, SetLogEmails(LogEmailsMask, ""))
+	log.Info("masked", emailField("user@example.org"))
+
+	require.NoError(t, SetLogEmails(LogEmailsHash, "key"))
+	log.Info("hashed", emailField("user@example.org"))
+
+	entries := logs.All()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "***@example.org", entries[0].ContextMap()["email"])
+	assert.Equal(t, (&emailPseudonymizer{mode: LogEmailsHash, key: []byte("key")}).pseudonymize("user@example.org"), entries[1].ContextMap()["email"])
+}
+
+func TestCommandField(t *testing.T) {
+	defer func() { logEmails = &emailPseudonymizer{mode: LogEmailsPlain} }()
+
+	cmd := exec.Command("/usr/bin/tar", "-C", "/var/vmail/example.org/user", "-u", "user@example.org")
+	command := func() any {
+		enc := zapcore.NewMapObjectEncoder()
+		commandField(cmd, "user@example.org", "/var/vmail/example.org/user").AddTo(enc)
+		return enc.Fields["command"]
+	}
+
+	assert.Equal(t, "/usr/bin/tar -C /var/vmail/example.org/user -u user@example.org", command())
+
+	require.NoError(t, SetLogEmails(LogEmailsMask, ""))
+	assert.Equal(t, "/usr/bin/tar -C *** -u ***@example.org", command())
+}
+
+func TestPathField(t *testing.T) {
+	defer func() { logEmails = &emailPseudonymizer{mode: LogEmailsPlain} }()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	log := zap.New(core)
+
+	log.Info("plain", pathField("path", "/var/vmail/example.org/user"))
+
+	require.NoError(t, SetLogEmails(LogEmailsHash, "key"))
+	log.Info("hashed", pathField("path", "/var/vmail/example.org/user"))
+
+	entries := logs.All()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "/var/vmail/example.org/user", entries[0].ContextMap()["path"])
+	assert.NotContains(t, entries[1].ContextMap(), "path")
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MaildirPurger removes the mail home of a user from the file system
+type MaildirPurger struct {
+	template       string
+	root           string
+	trashDir       string
+	trashRetention time.Duration
+}
+
+// NewMaildirPurger creates a new file system purge backend. The template
+// supports the Dovecot variables %u (user), %n (local part) and %d (domain),
+// resolved paths must stay inside root. With a trash directory, mail homes are
+// moved there and only removed after the trash retention.
+func NewMaildirPurger(template, root, trashDir string, trashRetention time.Duration) (*MaildirPurger, error) {
+	if !filepath.IsAbs(root) {
+		return nil, fmt.Errorf("maildir root must be absolute: %q", root)
+	}
+
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("maildir path template must be absolute: %q", template)
+	}
+
+	if !strings.Contains(template, "%u") && !strings.Contains(template, "%n") {
+		return nil, fmt.Errorf("maildir path template must contain %%u or %%n: %q", template)
+	}
+
+	if trashDir != "" && !filepath.IsAbs(trashDir) {
+		return nil, fmt.Errorf("maildir trash directory must be absolute: %q", trashDir)
+	}
+
+	return &MaildirPurger{
+		template:       template,
+		root:           filepath.Clean(root),
+		trashDir:       trashDir,
+		trashRetention: trashRetention,
+	}, nil
+}
+
+// resolve expands the path template and ensures the result stays inside the root
+func (p *MaildirPurger) resolve(email string) (string, error) {
+	if err := validateEmail(email); err != nil {
+		return "", fmt.Errorf("email validation failed: %w", err)
+	}
+
+	localPart, domain, _ := strings.Cut(email, "@")
+	for _, part := range []string{localPart, domain} {
+		if strings.ContainsAny(part, "/%") || part == "." || part == ".." {
+			return "", fmt.Errorf("%w: unsafe for path template", ErrInvalidEmail)
+		}
+	}
+
+	replacer := strings.NewReplacer("%%", "%", "%u", email, "%n", localPart, "%d", domain)
+	path := filepath.Clean(replacer.Replace(p.template))
+
+	if !isInside(p.root, path) {
+		return "", fmt.Errorf("resolved path %q is outside of %q", path, p.root)
+	}
+
+	// Resolve symlinks in the parent directories, the mail home itself must not be a link
+	realRoot, err := filepath.EvalSymlinks(p.root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve maildir root: %w", err)
+	}
+
+	realParent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return path, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve parent directory: %w", err)
+	}
+
+	realPath := filepath.Join(realParent, filepath.Base(path))
+	if !isInside(realRoot, realPath) {
+		return "", fmt.Errorf("resolved path %q escapes %q through a symlink", path, p.root)
+	}
+
+	if info, err := os.Lstat(realPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("mail home %q is a symlink", path)
+	}
+
+	return realPath, nil
+}
+
+// Purge removes the mail home or moves it into the trash directory
+func (p *MaildirPurger) Purge(ctx context.Context, email string) error {
+	path, err := p.resolve(email)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+		logger.Info("Mail home does not exist, nothing to purge",
+			emailField(email),
+			pathField("path", path))
+		return nil
+	}
+
+	if p.trashDir != "" {
+		if err := os.MkdirAll(p.trashDir, 0o700); err != nil {
+			return fmt.Errorf("failed to create trash directory: %w", err)
+		}
+
+		target := filepath.Join(p.trashDir, fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405Z"), email))
+		if err := os.Rename(path, target); err != nil {
+			return fmt.Errorf("failed to move mail home to trash: %w", err)
+		}
+
+		// The grace period starts now, not at the last modification of the mail home
+		now := time.Now()
+		if err := os.Chtimes(target, now, now); err != nil {
+			return fmt.Errorf("failed to timestamp mail home in trash: %w", err)
+		}
+
+		logger.Debug("Mail home moved to trash",
+			emailField(email),
+			pathField("path", path),
+			pathField("trash", target))
+		return nil
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("failed to remove mail home: %w", err)
+	}
+
+	logger.Debug("Mail home removed",
+		emailField(email),
+		pathField("path", path))
+	return nil
+}
+
+// Prepare does nothing, the mail home is derived from the email address
+func (p *MaildirPurger) Prepare(email string) (string, error) {
+	return "", nil
+}
+
+// Verify checks that the mail home is gone
+func (p *MaildirPurger) Verify(email, prepared string) (string, error) {
+	path, err := p.resolve(email)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return "path=" + path, nil
+}
+
+// Describe returns the path template
+func (p *MaildirPurger) Describe() string {
+	if p.trashDir != "" {
+		return fmt.Sprintf("maildir (%s, trash %s)", p.template, p.trashDir)
+	}
+
+	return fmt.Sprintf("maildir (%s)", p.template)
+}
+
+// Check verifies that the root is an accessible directory
+func (p *MaildirPurger) Check() error {
+	dir, err := os.Open(p.root)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	// Reading a single entry fails for files and unreadable directories
+	if _, err := dir.Readdirnames(1); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+}
+
+// Cleanup removes mail homes from the trash directory after the grace period
+func (p *MaildirPurger) Cleanup() {
+	if p.trashDir == "" {
+		return
+	}
+
+	entries, err := os.ReadDir(p.trashDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to list trash directory", zap.Error(err))
+		return
+	}
+
+	cutoffTime := time.Now().Add(-p.trashRetention)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoffTime) {
+			continue
+		}
+
+		path := filepath.Join(p.trashDir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			logger.Error("Failed to remove mail home from trash", pathField("path", path), zap.Error(err))
+			continue
+		}
+
+		logger.Info("Mail home removed from trash", pathField("path", path))
+	}
+}
+
+// isInside reports whether path is located below root
+func isInside(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type MaildirPurgerTestSuite struct {
+	suite.Suite
+	root string
+}
+
+func (s *MaildirPurgerTestSuite) SetupTest() {
+	logger = zap.NewNop()
+	s.root = s.T().TempDir()
+}
+
+// mailHome creates a mail home with a message below the root
+func (s *MaildirPurgerTestSuite) mailHome(domain, user string) string {
+	home := filepath.Join(s.root, domain, user)
+	s.Require().NoError(os.MkdirAll(filepath.Join(home, "Maildir", "cur"), 0o700))
+	s.Require().NoError(os.WriteFile(filepath.Join(home, "Maildir", "cur", "1.mail"), []byte("Subject: test\n"), 0o600))
+	return home
+}
+
+func (s *MaildirPurgerTestSuite) newPurger(trashDir string) *MaildirPurger {
+	purger, err := NewMaildirPurger(filepath.Join(s.root, "%d", "%n"), s.root, trashDir, time.Hour)
+	s.Require().NoError(err)
+	return purger
+}
+
+func (s *MaildirPurgerTestSuite) TestNewMaildirPurger_Invalid() {
+	_, err := NewMaildirPurger("/var/vmail/%d/%n", "vmail", "", 0)
+	s.Error(err)
+
+	_, err = NewMaildirPurger("vmail/%d/%n", "/var/vmail", "", 0)
+	s.Error(err)
+
+	_, err = NewMaildirPurger("/var/vmail/%d", "/var/vmail", "", 0)
+	s.Error(err)
+
+	_, err = NewMaildirPurger("/var/vmail/%d/%n", "/var/vmail", "trash", 0)
+	s.Error(err)
+}
+
+func (s *MaildirPurgerTestSuite) TestPurge() {
+	home := s.mailHome("example.org", "user")
+	other := s.mailHome("example.org", "other")
+	purger := s.newPurger("")
+
+	s.NoError(purger.Purge(context.Background(), "user@example.org"))
+	s.NoDirExists(home)
+	s.DirExists(other)
+
+	residual, err := purger.Verify("user@example.org", "")
+	s.NoError(err)
+	s.Empty(residual)
+}
+
+func (s *MaildirPurgerTestSuite) TestPurge_NotExisting() {
+	purger := s.newPurger("")
+
+	s.NoError(purger.Purge(context.Background(), "user@example.org"))
+}
+
+func (s *MaildirPurgerTestSuite) TestPurge_InvalidEmail() {
+	purger := s.newPurger("")
+
+	s.ErrorIs(purger.Purge(context.Background(), "*@example.org"), ErrInvalidEmail)
+	s.ErrorIs(purger.Purge(context.Background(), "..@example.org"), ErrInvalidEmail)
+	s.ErrorIs(purger.Purge(context.Background(), "user@.."), ErrInvalidEmail)
+	s.ErrorIs(purger.Purge(context.Background(), "a/b@example.org"), ErrInvalidEmail)
+}
+
+func (s *MaildirPurgerTestSuite) TestPurge_OutsideRoot() {
+	purger, err := NewMaildirPurger(filepath.Join(s.root, "..", "%n"), s.root, "", 0)
+	s.Require().NoError(err)
+
+	s.Error(purger.Purge(context.Background(), "user@example.org"))
+}
+
+func (s *MaildirPurgerTestSuite) TestPurge_SymlinkEscape() {
+	outside := s.T().TempDir()
+	s.Require().NoError(os.MkdirAll(filepath.Join(outside, "user"), 0o700))
+	s.Require().NoError(os.Symlink(outside, filepath.Join(s.root, "example.org")))
+	purger := s.newPurger("")
+
+	s.Error(purger.Purge(context.Background(), "user@example.org"))
+	s.DirExists(filepath.Join(outside, "user"))
+}
+
+func (s *MaildirPurgerTestSuite) TestPurge_SymlinkHome() {
+	outside := s.T().TempDir()
+	s.Require().NoError(os.MkdirAll(filepath.Join(s.root, "example.org"), 0o700))
+	s.Require().NoError(os.Symlink(outside, filepath.Join(s.root, "example.org", "user")))
+	purger := s.newPurger("")
+
+	s.Error(purger.Purge(context.Background(), "user@example.org"))
+	s.DirExists(outside)
+}
+
+func (s *MaildirPurgerTestSuite) TestPurge_Trash() {
+	home := s.mailHome("example.org", "user")
+	trashDir := filepath.Join(s.root, ".trash")
+	purger := s.newPurger(trashDir)
+
+	s.NoError(purger.Purge(context.Background(), "user@example.org"))
+	s.NoDirExists(home)
+
+	entries, err := os.ReadDir(trashDir)
+	s.Require().NoError(err)
+	s.Require().Len(entries, 1)
+	s.Contains(entries[0].Name(), "user@example.org")
+
+	// Still within the grace period
+	purger.Cleanup()
+	entries, err = os.ReadDir(trashDir)
+	s.Require().NoError(err)
+	s.Len(entries, 1)
+
+	past := time.Now().Add(-2 * time.Hour)
+	s.Require().NoError(os.Chtimes(filepath.Join(trashDir, entries[0].Name()), past, past))
+
+	purger.Cleanup()
+	entries, err = os.ReadDir(trashDir)
+	s.Require().NoError(err)
+	s.Empty(entries)
+}
+
+func (s *MaildirPurgerTestSuite) TestVerify_Residual() {
+	home := s.mailHome("example.org", "user")
+	purger := s.newPurger("")
+
+	residual, err := purger.Verify("user@example.org", "")
+	s.NoError(err)
+	s.Equal("path="+home, residual)
+}
+
+func (s *MaildirPurgerTestSuite) TestCheck() {
+	s.NoError(s.newPurger("").Check())
+
+	purger, err := NewMaildirPurger("/nonexistent/%d/%n", "/nonexistent", "", 0)
+	s.Require().NoError(err)
+	s.Error(purger.Check())
+}
+
+func (s *MaildirPurgerTestSuite) TestDescribe() {
+	s.Equal("maildir (/var/vmail/%d/%n)", (&MaildirPurger{template: "/var/vmail/%d/%n"}).Describe())
+	s.Equal("maildir (/var/vmail/%d/%n, trash /var/vmail/.trash)", (&MaildirPurger{template: "/var/vmail/%d/%n", trashDir: "/var/vmail/.trash"}).Describe())
+}
+
+func TestMaildirPurgerTestSuite(t *testing.T) {
+	suite.Run(t, new(MaildirPurgerTestSuite))
+}
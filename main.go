@@ -2,10 +2,11 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -14,21 +15,11 @@ import (
 var logger *zap.Logger
 
 func init() {
-	// Initialize logger with default config
-	logLevel := "info"
-	if os.Getenv("LOG_LEVEL") != "" {
-		logLevel = os.Getenv("LOG_LEVEL")
-	}
-
-	level, err := zapcore.ParseLevel(logLevel)
-	if err != nil {
-		log.Fatal(err)
-	}
-
+	// Initialize logger with default config, the level is applied from LOG_LEVEL in main
 	logger = zap.New(zapcore.NewCore(
 		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
 		zapcore.Lock(os.Stdout),
-		level,
+		logLevel,
 	))
 }
 
@@ -37,45 +28,217 @@ func main() {
 		_ = logger.Sync()
 	}()
 
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// Load configuration
 	config := BuildConfig()
+	if err := InitLogLevel(config.LogLevel); err != nil {
+		logger.Fatal("Invalid LOG_LEVEL", zap.Error(err))
+	}
+
+	configuredLogger, err := NewLogger(config)
+	if err != nil {
+		logger.Fatal("Failed to initialize logger", zap.Error(err))
+	}
+	logger = configuredLogger
+
+	// Spans are only recorded with an OTLP endpoint
+	shutdownTracing := func(context.Context) error { return nil }
+	if config.TracingOTLPEndpoint != "" {
+		if shutdownTracing, err = InitTracing(config.TracingOTLPEndpoint); err != nil {
+			logger.Fatal("Failed to initialize tracing", zap.Error(err))
+		}
+	}
+
+	if err := SetLogEmails(config.LogEmails, config.LogEmailHashKey); err != nil {
+		logger.Fatal("Invalid LOG_EMAILS", zap.Error(err))
+	}
+
 	logger.Info("Configuration loaded",
+		zap.String("logLevel", config.LogLevel),
+		zap.String("logFormat", config.LogFormat),
+		zap.String("logOutput", config.LogOutput),
+		zap.Bool("tracing", config.TracingOTLPEndpoint != ""),
+		zap.String("logEmails", config.LogEmails),
 		zap.String("listenAddr", config.ListenAddr),
+		zap.Bool("tls", config.TLSCertFile != ""),
+		zap.Bool("clientCertRequired", config.TLSClientCAFile != ""),
 		zap.String("databasePath", config.DatabasePath),
 		zap.Int("retentionHours", config.RetentionHours),
-		zap.Duration("tickInterval", config.TickInterval))
+		zap.Duration("tickInterval", config.TickInterval),
+		zap.Strings("allowedDomains", config.AllowedDomains),
+		zap.Strings("allowedSources", config.AllowedSources),
+		zap.String("webhookSignatureScheme", config.WebhookSignatureScheme),
+		zap.Any("webhookRoutes", config.WebhookRoutes),
+		zap.Strings("trustedProxies", config.TrustedProxies),
+		zap.String("trustedProxyHeader", config.TrustedProxyHeader),
+		zap.String("purgeBackend", config.PurgeBackend),
+		zap.Any("domainPurgeBackends", config.DomainPurgeBackends),
+		zap.String("doveadmBackend", config.DoveadmBackend))
+
+	normalizer := NewEmailNormalizer(config.FoldLocalPart)
 
 	// Initialize database
-	db, err := NewDatabase(config.DatabasePath)
+	db, err := NewDatabase(config.DatabasePath, config.DomainHoldsPath, normalizer)
 	if err != nil {
 		logger.Fatal("Failed to initialize database", zap.Error(err))
 	}
 	defer db.Close()
 
+	protected, err := NewProtectedList(config.ProtectedAccounts)
+	if err != nil {
+		logger.Fatal("Invalid protected accounts", zap.Error(err))
+	}
+
+	allowedDomains, err := NewDomainFilter(config.AllowedDomains)
+	if err != nil {
+		logger.Fatal("Invalid allowed domains", zap.Error(err))
+	}
+
+	var doveadm Doveadm = NewDoveadmExec(config.DoveadmPath, config.UseSudo)
+	if config.DoveadmBackend == DoveadmBackendHTTP {
+		doveadm = NewDoveadmHTTP(config.DoveadmAPIURL, config.DoveadmAPIKey, config.DoveadmAPIPassword, config.DoveadmAPITimeout)
+	}
+
+	purgers, err := BuildPurgers(config, doveadm)
+	if err != nil {
+		logger.Fatal("Failed to initialize purge backends", zap.Error(err))
+	}
+
+	var archiver *Archiver
+	if config.ArchiveDir != "" {
+		archiveDomains, err := NewDomainFilter(config.ArchiveDomains)
+		if err != nil {
+			logger.Fatal("Invalid archive domains", zap.Error(err))
+		}
+
+		archiver, err = NewArchiver(config.ArchiveDir, config.ArchiveRecipients, archiveDomains,
+			config.ArchiveRetention, doveadm, config.UseSudo)
+		if err != nil {
+			logger.Fatal("Failed to initialize archiver", zap.Error(err))
+		}
+	}
+
+	var notifier *Notifier
+	if len(config.NotifyURLs) > 0 {
+		notifier, err = NewNotifier(config.NotifyURLs, config.NotifySecret, config.NotifyEvents, config.NotifyOutboxPath,
+			config.NotifyMaxAttempts, config.NotifyTimeout)
+		if err != nil {
+			logger.Fatal("Failed to initialize notifier", zap.Error(err))
+		}
+	}
+
+	var alerter *Alerter
+	if config.AlertSMTPAddr != "" {
+		alerter, err = NewAlerter(db, config.RetentionHours, config.AlertSMTPAddr, config.AlertSMTPTimeout, config.AlertSMTPUsername, config.AlertSMTPPassword,
+			config.AlertFrom, config.AlertTo, config.AlertInterval, config.AlertFailureThreshold, config.AlertQueueThreshold,
+			config.AlertOverdueAfter)
+		if err != nil {
+			logger.Fatal("Failed to initialize alerts", zap.Error(err))
+		}
+	}
+
+	hooks := NewHooks(config.PrePurgeHook, config.PostPurgeHook, config.HookTimeout, config.PreHookFailure)
+
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Deliver outbound events
+	go notifier.Start(ctx)
+
 	// Start worker
-	worker := NewWorker(db, config.TickInterval, config.RetentionHours, config.DoveadmPath, config.UseSudo)
+	worker := NewWorker(db, purgers, config.TickInterval, config.RetentionHours, normalizer, WorkerOptions{
+		TickWarnDuration: config.TickWarnDuration,
+		Protected:        protected,
+		Archiver:         archiver,
+		Hooks:            hooks,
+		Notifier:         notifier,
+		Alerter:          alerter,
+	})
 	go worker.Start(ctx)
 
+	sources, err := NewSourceFilter(config.AllowedSources, config.TrustedProxies, config.TrustedProxyHeader)
+	if err != nil {
+		logger.Fatal("Failed to parse source allowlist", zap.Error(err))
+	}
+
+	webhooks, err := BuildWebhookRoutes(config)
+	if err != nil {
+		logger.Fatal("Invalid webhook route configuration", zap.Error(err))
+	}
+
 	// Start HTTP server
-	server := NewServer(config.WebhookSecret, db)
+	server := NewServer(config.AdminToken, db, normalizer, webhooks, ServerOptions{
+		Protected:   protected,
+		Domains:     allowedDomains,
+		Notifier:    notifier,
+		Readiness:   BuildReadinessChecks(db, purgers, worker),
+		Worker:      worker,
+		Sources:     sources,
+		MaxBodySize: int64(config.MaxBodySize),
+	})
+
+	var tlsConfig *tls.Config
+	if config.TLSCertFile != "" {
+		tlsConfig, err = NewTLSConfig(config.TLSCertFile, config.TLSKeyFile, config.TLSClientCAFile)
+		if err != nil {
+			logger.Fatal("Failed to load TLS certificate", zap.Error(err))
+		}
+	}
 
 	// Setup graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
+	// SIGUSR1 requests an immediate worker run
+	triggerChan := make(chan os.Signal, 1)
+	signal.Notify(triggerChan, syscall.SIGUSR1)
+	go func() {
+		for range triggerChan {
+			worker.Trigger("SIGUSR1")
+		}
+	}()
+
+	// SIGUSR2 toggles debug logging
+	levelChan := make(chan os.Signal, 1)
+	signal.Notify(levelChan, syscall.SIGUSR2)
+	go func() {
+		for range levelChan {
+			ToggleDebugLogging("SIGUSR2")
+		}
+	}()
+
+	listener, err := Listen(config.ListenAddr, config.ListenSocketMode, config.ListenSocketOwner)
+	if err != nil {
+		logger.Fatal("Failed to listen", zap.String("address", config.ListenAddr), zap.Error(err))
+	}
+
 	// Start server in goroutine
 	go func() {
-		if err := server.Start(config.ListenAddr); err != nil {
+		if err := server.Start(listener, tlsConfig); err != nil {
 			logger.Fatal("Failed to start server", zap.Error(err))
 		}
 	}()
 
+	// Tell systemd that startup is complete and keep its watchdog fed
+	sdNotify("READY=1")
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go worker.RunWatchdog(ctx, interval)
+	}
+
 	// Wait for shutdown signal
 	<-sigChan
 	logger.Info("Shutdown signal received, stopping...")
+	sdNotify("STOPPING=1")
 	cancel()
+
+	// Export the spans of the last requests and purges
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Warn("Failed to flush traces", zap.Error(err))
+	}
 }
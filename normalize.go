@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// Length limits from RFC 5321 section 4.5.3.1
+const (
+	maxLocalPartLength = 64
+	maxDomainLength    = 255
+	maxEmailLength     = 254
+)
+
+// idnaProfile converts internationalized domain names to their punycode form
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.VerifyDNSLength(true),
+)
+
+// EmailNormalizer converts email addresses into the canonical form used by
+// the server, the database and the worker
+type EmailNormalizer struct {
+	// FoldLocalPart lowercases the local part in addition to the domain
+	FoldLocalPart bool
+}
+
+// NewEmailNormalizer creates a new email normalizer
+func NewEmailNormalizer(foldLocalPart bool) *EmailNormalizer {
+	return &EmailNormalizer{FoldLocalPart: foldLocalPart}
+}
+
+// Normalize returns the canonical form of an email address. The domain is
+// lowercased and converted to punycode, the local part is optionally case
+// folded. The result is guaranteed to pass validateEmail.
+func (n *EmailNormalizer) Normalize(email string) (string, error) {
+	if email == "" {
+		return "", fmt.Errorf("%w: empty email", ErrInvalidEmail)
+	}
+
+	if !utf8.ValidString(email) {
+		return "", fmt.Errorf("%w: invalid UTF-8", ErrInvalidEmail)
+	}
+
+	for _, r := range email {
+		if !unicode.IsPrint(r) {
+			return "", fmt.Errorf("%w: contains non-printable characters", ErrInvalidEmail)
+		}
+	}
+
+	localPart, domain, found := strings.Cut(email, "@")
+	if !found || localPart == "" || domain == "" || strings.Contains(domain, "@") {
+		return "", fmt.Errorf("%w: invalid format", ErrInvalidEmail)
+	}
+
+	// Validate the raw input first so that IDNA mapping cannot turn
+	// forbidden characters into allowed ones or vice versa
+	if err := validateEmail(email); err != nil {
+		return "", err
+	}
+
+	asciiDomain, err := idnaProfile.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid domain: %v", ErrInvalidEmail, err)
+	}
+
+	if n.FoldLocalPart {
+		localPart = strings.ToLower(localPart)
+	}
+
+	if len(localPart) > maxLocalPartLength {
+		return "", fmt.Errorf("%w: local part exceeds %d octets", ErrInvalidEmail, maxLocalPartLength)
+	}
+	if len(asciiDomain) > maxDomainLength {
+		return "", fmt.Errorf("%w: domain exceeds %d octets", ErrInvalidEmail, maxDomainLength)
+	}
+
+	normalized := localPart + "@" + asciiDomain
+	if len(normalized) > maxEmailLength {
+		return "", fmt.Errorf("%w: address exceeds %d octets", ErrInvalidEmail, maxEmailLength)
+	}
+
+	if err := validateEmail(normalized); err != nil {
+		return "", err
+	}
+
+	return normalized, nil
+}
+
+// NormalizeDomain returns the lowercased punycode form of a domain
+func (n *EmailNormalizer) NormalizeDomain(domain string) (string, error) {
+	return normalizeDomain(domain)
+}
+
+// normalizeDomain returns the lowercased punycode form of a domain, like the
+// domains of normalized email addresses
+func normalizeDomain(domain string) (string, error) {
+	if domain == "" || strings.ContainsAny(domain, "@*?") {
+		return "", fmt.Errorf("%w: invalid domain", ErrInvalidEmail)
+	}
+
+	asciiDomain, err := idnaProfile.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid domain: %v", ErrInvalidEmail, err)
+	}
+
+	return asciiDomain, nil
+}
+
+// key returns the normalized email address used for comparisons, falling
+// back to the raw value for entries that cannot be normalized
+func (n *EmailNormalizer) key(email string) string {
+	if normalized, err := n.Normalize(email); err == nil {
+		return normalized
+	}
+
+	return email
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailNormalizer_Normalize(t *testing.T) {
+	tests := []struct {
+		name          string
+		email         string
+		foldLocalPart bool
+		want          string
+		wantErr       bool
+	}{
+		{"already normalized", "user@example.org", true, "user@example.org", false},
+		{"uppercase domain", "user@EXAMPLE.org", false, "user@example.org", false},
+		{"uppercase local part folded", "User@Example.org", true, "user@example.org", false},
+		{"uppercase local part kept", "User@Example.org", false, "User@example.org", false},
+		{"idn domain", "user@bücher.example", true, "user@xn--bcher-kva.example", false},
+		{"punycode domain", "user@xn--bcher-kva.example", true, "user@xn--bcher-kva.example", false},
+		{"utf-8 local part", "Jürgen@example.org", true, "jürgen@example.org", false},
+		{"empty email", "", true, "", true},
+		{"missing local part", "@example.org", true, "", true},
+		{"missing domain", "user@", true, "", true},
+		{"multiple at signs", "user@exam@ple.org", true, "", true},
+		{"wildcard", "user*@example.org", true, "", true},
+		{"invalid domain label", "user@-example.org", true, "", true},
+		{"control character", "user\x00@example.org", true, "", true},
+		{"invalid utf-8", "user\xff@example.org", true, "", true},
+		{"local part too long", strings.Repeat("a", 65) + "@example.org", true, "", true},
+		{"address too long", "user@" + strings.Repeat("a", 62) + "." + strings.Repeat("b", 62) + "." + strings.Repeat("c", 62) + "." + strings.Repeat("d", 62) + ".org", true, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewEmailNormalizer(tt.foldLocalPart).Normalize(tt.email)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidEmail)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func FuzzEmailNormalizer_Normalize(f *testing.F) {
+	seeds := []string{
+		"user@example.org",
+		"User@Example.org",
+		"user@bücher.example",
+		"*@example.org",
+		"user?@example.org",
+		"user@example.org;rm -rf /",
+		"user@example.org\ninjected",
+		"user@ｅｘａｍｐｌｅ.org",
+		"user@example。org",
+	}
+	for _, seed := range seeds {
+		f.Add(seed, true)
+		f.Add(seed, false)
+	}
+
+	f.Fuzz(func(t *testing.T, email string, foldLocalPart bool) {
+		normalizer := NewEmailNormalizer(foldLocalPart)
+
+		normalized, err := normalizer.Normalize(email)
+		if err != nil {
+			return
+		}
+
+		// Normalized addresses must always be safe to pass to doveadm
+		if err := validateEmail(normalized); err != nil {
+			t.Fatalf("normalized %q to unsafe %q: %v", email, normalized, err)
+		}
+		if strings.Count(normalized, "@") != 1 {
+			t.Fatalf("normalized %q to %q without exactly one @", email, normalized)
+		}
+		if !utf8.ValidString(normalized) || len(normalized) > maxEmailLength {
+			t.Fatalf("normalized %q to invalid %q", email, normalized)
+		}
+
+		// Normalization must be idempotent
+		again, err := normalizer.Normalize(normalized)
+		if err != nil || again != normalized {
+			t.Fatalf("normalize not idempotent: %q -> %q -> %q (%v)", email, normalized, again, err)
+		}
+	})
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Outbound event types
+const (
+	EventTypeMailboxPurged      = "mailbox.purged"
+	EventTypeMailboxPurgeFailed = "mailbox.purge_failed"
+	EventTypeMailboxQueued      = "mailbox.queued"
+	EventTypeMailboxCancelled   = "mailbox.cancelled"
+)
+
+// Retry schedule for outbound webhooks
+const (
+	notifyRetryBase = 30 * time.Second
+	notifyRetryMax  = time.Hour
+)
+
+var outboxHeader = []string{"id", "url", "body", "attempts", "next_attempt_at", "last_error"}
+
+// MailboxEvent is an event sent to the configured webhook URLs
+type MailboxEvent struct {
+	Type      string           `json:"type"`
+	Timestamp time.Time        `json:"timestamp"`
+	Data      MailboxEventData `json:"data"`
+}
+
+// MailboxEventData holds the mailbox the event is about
+type MailboxEventData struct {
+	Email   string `json:"email"`
+	Backend string `json:"backend,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// outboxEntry is a pending delivery of an event to a single URL
+type outboxEntry struct {
+	ID            string
+	URL           string
+	Body          string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+}
+
+// Notifier delivers signed mailbox events to webhook URLs. Events are stored
+// in a CSV outbox first, so that pending deliveries survive restarts.
+type Notifier struct {
+	urls         []string
+	secret       string
+	events       []string
+	outboxPath   string
+	maxAttempts  int
+	pollInterval time.Duration
+	client       *http.Client
+	wake         chan struct{}
+	mu           sync.Mutex
+}
+
+// NewNotifier creates a new notifier sending the given event types (all if empty) to urls
+func NewNotifier(urls []string, secret string, events []string, outboxPath string, maxAttempts int, timeout time.Duration) (*Notifier, error) {
+	for _, event := range events {
+		switch event {
+		case EventTypeMailboxPurged, EventTypeMailboxPurgeFailed, EventTypeMailboxQueued, EventTypeMailboxCancelled:
+		default:
+			return nil, fmt.Errorf("unknown event type %q", event)
+		}
+	}
+
+	// Pending deliveries from a previous run are kept
+	if _, err := os.Stat(outboxPath); errors.Is(err, os.ErrNotExist) {
+		if err := initFile(outboxPath, outboxHeader); err != nil {
+			return nil, fmt.Errorf("failed to initialize outbox: %w", err)
+		}
+	}
+
+	return &Notifier{
+		urls:         urls,
+		secret:       secret,
+		events:       events,
+		outboxPath:   outboxPath,
+		maxAttempts:  maxAttempts,
+		pollInterval: 10 * time.Second,
+		client:       &http.Client{Timeout: timeout},
+		wake:         make(chan struct{}, 1),
+	}, nil
+}
+
+// Notify queues an event for delivery to all webhook URLs
+func (n *Notifier) Notify(eventType string, data MailboxEventData) {
+	if n == nil || (len(n.events) > 0 && !slices.Contains(n.events, eventType)) {
+		return
+	}
+
+	body, err := json.Marshal(MailboxEvent{
+		Type:      eventType,
+		Timestamp: time.Now().UTC().Truncate(time.Second),
+		Data:      data,
+	})
+	if err != nil {
+		logger.Error("Failed to encode event", zap.String("type", eventType), zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	var entries []outboxEntry
+	for _, url := range n.urls {
+		entries = append(entries, outboxEntry{
+			ID:            newOutboxID(),
+			URL:           url,
+			Body:          string(body),
+			NextAttemptAt: now,
+		})
+	}
+
+	n.mu.Lock()
+	err = n.update(func(outbox []outboxEntry) []outboxEntry {
+		return append(outbox, entries...)
+	})
+	n.mu.Unlock()
+	if err != nil {
+		logger.Error("Failed to queue event",
+			zap.String("type", eventType),
+			emailField(data.Email),
+			zap.Error(err))
+		return
+	}
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start delivers queued events until the context is cancelled
+func (n *Notifier) Start(ctx context.Context) {
+	if n == nil {
+		return
+	}
+
+	ticker := time.NewTicker(n.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		n.deliver()
+
+		select {
+		case <-ticker.C:
+		case <-n.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver sends all due events and reschedules failed deliveries
+func (n *Notifier) deliver() {
+	n.mu.Lock()
+	outbox, err := n.readOutbox()
+	n.mu.Unlock()
+	if err != nil {
+		logger.Error("Failed to read outbox", zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	results := make(map[string]error)
+	for _, entry := range outbox {
+		if entry.NextAttemptAt.After(now) {
+			continue
+		}
+
+		results[entry.ID] = n.send(entry)
+	}
+
+	if len(results) == 0 {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// Events queued in the meantime are kept as they are
+	err = n.update(func(outbox []outboxEntry) []outboxEntry {
+		var pending []outboxEntry
+		for _, entry := range outbox {
+			sendErr, attempted := results[entry.ID]
+			if !attempted {
+				pending = append(pending, entry)
+				continue
+			}
+
+			if sendErr == nil {
+				logger.Debug("Event delivered", zap.String("url", entry.URL), zap.String("id", entry.ID))
+				continue
+			}
+
+			entry.Attempts++
+			entry.LastError = sendErr.Error()
+			if entry.Attempts >= n.maxAttempts {
+				// The body holds the plain email address, only its type and pseudonymized address are logged
+				var event MailboxEvent
+				_ = json.Unmarshal([]byte(entry.Body), &event)
+				logger.Error("Giving up on event delivery",
+					zap.String("url", entry.URL),
+					zap.String("id", entry.ID),
+					zap.String("type", event.Type),
+					emailField(event.Data.Email),
+					zap.Int("attempts", entry.Attempts),
+					zap.Error(sendErr))
+				continue
+			}
+
+			entry.NextAttemptAt = now.Add(retryDelay(entry.Attempts))
+			logger.Warn("Event delivery failed, retrying",
+				zap.String("url", entry.URL),
+				zap.Int("attempts", entry.Attempts),
+				zap.Time("nextAttemptAt", entry.NextAttemptAt),
+				zap.Error(sendErr))
+			pending = append(pending, entry)
+		}
+
+		return pending
+	})
+	if err != nil {
+		logger.Error("Failed to update outbox", zap.Error(err))
+	}
+}
+
+// send posts a single event with its signature
+func (n *Notifier) send(entry outboxEntry) error {
+	req, err := http.NewRequest(http.MethodPost, entry.URL, bytes.NewReader([]byte(entry.Body)))
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, []byte(n.secret))
+	mac.Write([]byte(entry.Body))
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Signature", hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// update applies fn to the outbox and writes the result, the caller must hold the lock
+func (n *Notifier) update(fn func([]outboxEntry) []outboxEntry) error {
+	outbox, err := n.readOutbox()
+	if err != nil {
+		return err
+	}
+
+	return n.writeOutbox(fn(outbox))
+}
+
+// readOutbox reads all pending deliveries, the caller must hold the lock
+func (n *Notifier) readOutbox() ([]outboxEntry, error) {
+	file, err := os.Open(n.outboxPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var outbox []outboxEntry
+	for i, record := range records {
+		if i == 0 {
+			continue
+		}
+
+		attempts, _ := strconv.Atoi(field(record, 3))
+		nextAttemptAt, err := time.Parse(timeFormat, field(record, 4))
+		if err != nil {
+			nextAttemptAt = time.Now()
+		}
+
+		outbox = append(outbox, outboxEntry{
+			ID:            field(record, 0),
+			URL:           field(record, 1),
+			Body:          field(record, 2),
+			Attempts:      attempts,
+			NextAttemptAt: nextAttemptAt,
+			LastError:     field(record, 5),
+		})
+	}
+
+	return outbox, nil
+}
+
+// writeOutbox replaces the outbox atomically, the caller must hold the lock
+func (n *Notifier) writeOutbox(outbox []outboxEntry) error {
+	tmpPath := n.outboxPath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	_ = writer.Write(outboxHeader)
+	for _, e := range outbox {
+		_ = writer.Write([]string{e.ID, e.URL, e.Body, strconv.Itoa(e.Attempts), e.NextAttemptAt.Format(timeFormat), e.LastError})
+	}
+	writer.Flush()
+
+	if err := errors.Join(writer.Error(), file.Sync(), file.Close()); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, n.outboxPath)
+}
+
+// retryDelay returns the exponential backoff after the given number of failed attempts
+func retryDelay(attempts int) time.Duration {
+	delay := notifyRetryBase
+	for i := 1; i < attempts && delay < notifyRetryMax; i++ {
+		delay *= 2
+	}
+
+	return min(delay, notifyRetryMax)
+}
+
+// newOutboxID returns a random identifier for an outbox entry
+func newOutboxID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type NotifierTestSuite struct {
+	suite.Suite
+	server     *httptest.Server
+	outboxPath string
+
+	mu       sync.Mutex
+	status   int
+	received []MailboxEvent
+}
+
+func (s *NotifierTestSuite) SetupTest() {
+	logger = zap.NewNop()
+	s.outboxPath = filepath.Join(s.T().TempDir(), "outbox.csv")
+	s.status = http.StatusOK
+	s.received = nil
+
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mac := hmac.New(sha256.New, []byte("test-secret"))
+		mac.Write(body)
+		if r.Header.Get("X-Webhook-Signature") != hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var event MailboxEvent
+		s.NoError(json.Unmarshal(body, &event))
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.status == http.StatusOK {
+			s.received = append(s.received, event)
+		}
+		w.WriteHeader(s.status)
+	}))
+}
+
+func (s *NotifierTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *NotifierTestSuite) newNotifier(events []string) *Notifier {
+	notifier, err := NewNotifier([]string{s.server.URL}, "test-secret", events, s.outboxPath, 3, time.Second)
+	s.Require().NoError(err)
+	return notifier
+}
+
+func (s *NotifierTestSuite) outbox(notifier *Notifier) []outboxEntry {
+	outbox, err := notifier.readOutbox()
+	s.Require().NoError(err)
+	return outbox
+}
+
+func (s *NotifierTestSuite) TestNewNotifier_UnknownEvent() {
+	_, err := NewNotifier([]string{s.server.URL}, "test-secret", []string{"mailbox.unknown"}, s.outboxPath, 3, time.Second)
+	s.Error(err)
+}
+
+func (s *NotifierTestSuite) TestNilNotifier() {
+	var notifier *Notifier
+
+	notifier.Notify(EventTypeMailboxPurged, MailboxEventData{Email: "test@example.com"})
+}
+
+func (s *NotifierTestSuite) TestDeliver() {
+	notifier := s.newNotifier(nil)
+
+	notifier.Notify(EventTypeMailboxPurged, MailboxEventData{Email: "test@example.com", Backend: "fake"})
+	s.Len(s.outbox(notifier), 1)
+
+	notifier.deliver()
+
+	s.Require().Len(s.received, 1)
+	s.Equal(EventTypeMailboxPurged, s.received[0].Type)
+	s.Equal("test@example.com", s.received[0].Data.Email)
+	s.Equal("fake", s.received[0].Data.Backend)
+	s.Empty(s.outbox(notifier))
+}
+
+func (s *NotifierTestSuite) TestNotify_FilteredEvent() {
+	notifier := s.newNotifier([]string{EventTypeMailboxPurged})
+
+	notifier.Notify(EventTypeMailboxQueued, MailboxEventData{Email: "test@example.com"})
+	s.Empty(s.outbox(notifier))
+}
+
+func (s *NotifierTestSuite) TestDeliver_Retry() {
+	s.status = http.StatusServiceUnavailable
+	notifier := s.newNotifier(nil)
+
+	notifier.Notify(EventTypeMailboxQueued, MailboxEventData{Email: "test@example.com"})
+	notifier.deliver()
+
+	outbox := s.outbox(notifier)
+	s.Require().Len(outbox, 1)
+	s.Equal(1, outbox[0].Attempts)
+	s.Equal("unexpected status 503", outbox[0].LastError)
+	s.True(outbox[0].NextAttemptAt.After(time.Now()))
+
+	// Not due yet
+	notifier.deliver()
+	s.Equal(1, s.outbox(notifier)[0].Attempts)
+
+	// Pending deliveries survive a restart
+	s.status = http.StatusOK
+	s.Require().NoError(notifier.writeOutbox([]outboxEntry{{ID: outbox[0].ID, URL: outbox[0].URL, Body: outbox[0].Body, Attempts: 1, NextAttemptAt: time.Now()}}))
+
+	restarted := s.newNotifier(nil)
+	restarted.deliver()
+	s.Len(s.received, 1)
+	s.Empty(s.outbox(restarted))
+}
+
+func (s *NotifierTestSuite) TestDeliver_GiveUp() {
+	s.status = http.StatusInternalServerError
+	notifier := s.newNotifier(nil)
+
+	notifier.Notify(EventTypeMailboxCancelled, MailboxEventData{Email: "test@example.com"})
+	s.Require().NoError(notifier.writeOutbox([]outboxEntry{{ID: "1", URL: s.server.URL, Body: "{}", Attempts: 2, NextAttemptAt: time.Now()}}))
+
+	notifier.deliver()
+	s.Empty(s.outbox(notifier))
+}
+
+func (s *NotifierTestSuite) TestDeliver_GiveUpLogsNoEmail() {
+	core, logs := observer.New(zapcore.ErrorLevel)
+	logger = zap.New(core)
+	s.Require().NoError(SetLogEmails(LogEmailsMask, ""))
+	defer func() { logEmails = &emailPseudonymizer{mode: LogEmailsPlain} }()
+
+	s.status = http.StatusInternalServerError
+	notifier := s.newNotifier(nil)
+	body := `{"type":"mailbox.purged","data":{"email":"test@example.com"}}`
+	s.Require().NoError(notifier.writeOutbox([]outboxEntry{{ID: "1", URL: s.server.URL, Body: body, Attempts: 2, NextAttemptAt: time.Now()}}))
+
+	notifier.deliver()
+
+	entries := logs.FilterMessage("Giving up on event delivery").All()
+	s.Require().Len(entries, 1)
+	fields := entries[0].ContextMap()
+	s.Equal("1", fields["id"])
+	s.Equal(EventTypeMailboxPurged, fields["type"])
+	s.Equal("***@example.com", fields["email"])
+	s.NotContains(fields, "body")
+}
+
+func (s *NotifierTestSuite) TestRetryDelay() {
+	s.Equal(30*time.Second, retryDelay(1))
+	s.Equal(time.Minute, retryDelay(2))
+	s.Equal(time.Hour, retryDelay(20))
+}
+
+func TestNotifierTestSuite(t *testing.T) {
+	suite.Run(t, new(NotifierTestSuite))
+}
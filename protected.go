@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ProtectedList holds addresses and patterns of mailboxes that must never be purged.
+//
+// Supported entries:
+//   - "user@example.org" matches exactly this address
+//   - "postmaster@" matches the local part on every domain
+//   - "@example.org" matches every address of the domain
+//   - glob patterns like "admin-*@example.org" with *, ? and [...] like path.Match,
+//     except that * and ? match / as well, which is valid in local parts
+//
+// Internationalized domains are converted to punycode like email addresses.
+type ProtectedList struct {
+	patterns []string
+	globs    []*regexp.Regexp
+}
+
+// NewProtectedList creates a protected list from the given entries
+func NewProtectedList(entries []string) (*ProtectedList, error) {
+	list := &ProtectedList{}
+
+	for _, entry := range entries {
+		pattern := strings.ToLower(strings.TrimSpace(entry))
+		if pattern == "" {
+			continue
+		}
+
+		if !strings.Contains(pattern, "@") {
+			return nil, fmt.Errorf("invalid protected entry %q: missing @", entry)
+		}
+
+		// Glob patterns in the domain cannot be converted, they must be written in punycode
+		i := strings.LastIndex(pattern, "@")
+		if domain := pattern[i+1:]; domain != "" && !strings.ContainsAny(domain, "*?[") {
+			normalized, err := normalizeDomain(domain)
+			if err != nil {
+				return nil, fmt.Errorf("invalid protected entry %q: %w", entry, err)
+			}
+			pattern = pattern[:i+1] + normalized
+		}
+
+		// Expand shorthand notations to glob patterns
+		if strings.HasSuffix(pattern, "@") {
+			pattern += "*"
+		}
+		if strings.HasPrefix(pattern, "@") {
+			pattern = "*" + pattern
+		}
+
+		glob, err := compileGlob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protected entry %q: %w", entry, err)
+		}
+
+		list.patterns = append(list.patterns, pattern)
+		list.globs = append(list.globs, glob)
+	}
+
+	return list, nil
+}
+
+// Match returns the first pattern matching the email address
+func (p *ProtectedList) Match(email string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+
+	email = strings.ToLower(email)
+	for i, glob := range p.globs {
+		if glob.MatchString(email) {
+			return p.patterns[i], true
+		}
+	}
+
+	return "", false
+}
+
+// errBadGlob is returned for glob patterns with an unterminated character class or escape
+var errBadGlob = errors.New("syntax error in pattern")
+
+// compileGlob converts a glob pattern into a regular expression matching the whole address
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '\\':
+			i++
+			if i == len(pattern) {
+				return nil, errBadGlob
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, errBadGlob
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "^") {
+				class = class[1:]
+				b.WriteString("[^")
+			} else {
+				b.WriteString("[")
+			}
+			if class == "" {
+				return nil, errBadGlob
+			}
+			b.WriteString(strings.NewReplacer("[", `\[`, "^", `\^`).Replace(class))
+			b.WriteString("]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// logProtectedBlocked emits the log event for a blocked attempt to queue or purge a protected mailbox
+func logProtectedBlocked(log *zap.Logger, email, pattern, source string) {
+	log.Warn("Protected mailbox blocked",
+		zap.String("event", "protected_mailbox_blocked"),
+		emailField(email),
+		zap.String("pattern", pattern),
+		zap.String("source", source))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtectedList_Match(t *testing.T) {
+	list, err := NewProtectedList([]string{"postmaster@", "@example.net", "Admin@example.org", "team-*@example.com", "@bücher.example", "info@Müller.example"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		email     string
+		protected bool
+	}{
+		{"local part on any domain", "postmaster@example.org", true},
+		{"whole domain", "user@example.net", true},
+		{"exact address", "admin@example.org", true},
+		{"exact address case insensitive", "ADMIN@Example.org", true},
+		{"glob pattern", "team-ops@example.com", true},
+		{"internationalized domain", "user@xn--bcher-kva.example", true},
+		{"internationalized domain of an address", "info@xn--mller-kva.example", true},
+		{"slash in local part on protected domain", "a/b@example.net", true},
+		{"slash in local part of glob pattern", "team-a/b@example.com", true},
+		{"slash in protected local part", "postmaster@a/b", true},
+		{"unprotected address", "user@example.org", false},
+		{"subdomain of protected domain", "user@sub.example.net", false},
+		{"glob pattern other domain", "team-ops@example.org", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := list.Match(tt.email)
+			assert.Equal(t, tt.protected, ok)
+		})
+	}
+}
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		email   string
+		match   bool
+	}{
+		{"*@example.org", "a/b@example.org", true},
+		{"team-?@example.org", "team-1@example.org", true},
+		{"team-?@example.org", "team-12@example.org", false},
+		{"team-[0-9]@example.org", "team-1@example.org", true},
+		{"team-[^0-9]@example.org", "team-1@example.org", false},
+		{"team-[^0-9]@example.org", "team-a@example.org", true},
+		{"team.x@example.org", "teamyx@example.org", false},
+		{`team\*@example.org`, "team*@example.org", true},
+		{`team\*@example.org`, "teams@example.org", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.email, func(t *testing.T) {
+			glob, err := compileGlob(tt.pattern)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.match, glob.MatchString(tt.email))
+		})
+	}
+}
+
+func TestProtectedList_Nil(t *testing.T) {
+	var list *ProtectedList
+
+	_, ok := list.Match("postmaster@example.org")
+	assert.False(t, ok)
+}
+
+func TestNewProtectedList_Invalid(t *testing.T) {
+	_, err := NewProtectedList([]string{"postmaster"})
+	assert.Error(t, err)
+
+	_, err = NewProtectedList([]string{"[@example.org"})
+	assert.Error(t, err)
+
+	_, err = NewProtectedList([]string{"user@exa mple.org"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Purge backends
+const (
+	PurgeBackendDoveadm = "doveadm"
+	PurgeBackendMaildir = "maildir"
+)
+
+// Purger removes the data of a mailbox
+type Purger interface {
+	// Prepare looks up what Verify needs while the user still exists, e.g. the
+	// home directory, and is called before the purge
+	Prepare(email string) (string, error)
+	// Purge removes all data of the mailbox
+	Purge(ctx context.Context, email string) error
+	// Verify returns a description of the data left behind after a purge,
+	// which is empty if nothing is left. prepared is the result of Prepare.
+	Verify(email, prepared string) (string, error)
+	// Describe returns a short description of the backend for logging
+	Describe() string
+}
+
+// Purgers selects the purge backend by the domain of a mailbox
+type Purgers struct {
+	defaultPurger Purger
+	domains       map[string]Purger
+}
+
+// NewPurgers creates a purger selection with a default backend and per-domain overrides
+func NewPurgers(defaultPurger Purger, domains map[string]Purger) *Purgers {
+	purgers := &Purgers{
+		defaultPurger: defaultPurger,
+		domains:       make(map[string]Purger),
+	}
+
+	for domain, purger := range domains {
+		purgers.domains[strings.ToLower(domain)] = purger
+	}
+
+	return purgers
+}
+
+// For returns the purge backend responsible for the email address
+func (p *Purgers) For(email string) Purger {
+	if purger, ok := p.domains[emailDomain(email)]; ok {
+		return purger
+	}
+
+	return p.defaultPurger
+}
+
+// unique returns each backend once, the default backend first
+func (p *Purgers) unique() []Purger {
+	purgers := []Purger{p.defaultPurger}
+	for _, domain := range slices.Sorted(maps.Keys(p.domains)) {
+		if purger := p.domains[domain]; !slices.Contains(purgers, purger) {
+			purgers = append(purgers, purger)
+		}
+	}
+
+	return purgers
+}
+
+// Cleanup runs the periodic housekeeping of all backends that need it
+func (p *Purgers) Cleanup() {
+	for _, purger := range p.unique() {
+		if c, ok := purger.(interface{ Cleanup() }); ok {
+			c.Cleanup()
+		}
+	}
+}
+
+// Checks returns readiness checks for all backends that can be checked
+func (p *Purgers) Checks() []ReadinessCheck {
+	var checks []ReadinessCheck
+	for _, purger := range p.unique() {
+		if c, ok := purger.(interface{ Check() error }); ok {
+			checks = append(checks, ReadinessCheck{Name: "purge_backend", Target: purger.Describe(), Check: c.Check})
+		}
+	}
+
+	return checks
+}
+
+// BuildPurgers creates the default and per-domain purge backends from the configuration
+func BuildPurgers(config *Config, doveadm Doveadm) (*Purgers, error) {
+	backends := make(map[string]Purger)
+	backend := func(name string) (Purger, error) {
+		if purger, ok := backends[name]; ok {
+			return purger, nil
+		}
+
+		var purger Purger
+		switch name {
+		case PurgeBackendDoveadm:
+			purger = NewDoveadmPurger(doveadm, config.VerifyMode)
+		case PurgeBackendMaildir:
+			maildir, err := NewMaildirPurger(config.MaildirPathTemplate, config.MaildirRoot, config.MaildirTrashDir, config.MaildirTrashRetention)
+			if err != nil {
+				return nil, err
+			}
+			purger = maildir
+		default:
+			return nil, fmt.Errorf("unknown purge backend %q", name)
+		}
+
+		backends[name] = purger
+		return purger, nil
+	}
+
+	defaultPurger, err := backend(config.PurgeBackend)
+	if err != nil {
+		return nil, err
+	}
+
+	domains := make(map[string]Purger)
+	for domain, name := range config.DomainPurgeBackends {
+		normalized, err := normalizeDomain(domain)
+		if err != nil {
+			return nil, fmt.Errorf("domain %s: %w", domain, err)
+		}
+
+		if domains[normalized], err = backend(name); err != nil {
+			return nil, fmt.Errorf("domain %s: %w", domain, err)
+		}
+	}
+
+	return NewPurgers(defaultPurger, domains), nil
+}
+
+// DoveadmPurger purges mailboxes with doveadm, either through the binary or the HTTP API
+type DoveadmPurger struct {
+	doveadm    Doveadm
+	verifyMode string
+}
+
+// NewDoveadmPurger creates a new doveadm purge backend
+func NewDoveadmPurger(doveadm Doveadm, verifyMode string) *DoveadmPurger {
+	return &DoveadmPurger{
+		doveadm:    doveadm,
+		verifyMode: verifyMode,
+	}
+}
+
+// Purge executes doveadm purge for the mailbox
+func (p *DoveadmPurger) Purge(ctx context.Context, email string) error {
+	// Validate email to prevent wildcard attacks
+	if err := validateEmail(email); err != nil {
+		return fmt.Errorf("email validation failed: %w", err)
+	}
+
+	return p.doveadm.Purge(ctx, email)
+}
+
+// Prepare resolves the home directory for VerifyModeHome, which is no longer
+// possible once the user is deleted
+func (p *DoveadmPurger) Prepare(email string) (string, error) {
+	if p.verifyMode != VerifyModeHome {
+		return "", nil
+	}
+
+	if _, ok := p.doveadm.(HomeChecker); !ok {
+		return "", fmt.Errorf("home verification is not supported by %s", p.doveadm)
+	}
+
+	home, err := p.doveadm.UserHome(email)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve home before purge: %w", err)
+	}
+
+	return home, nil
+}
+
+// Verify checks the mailbox according to the verification mode
+func (p *DoveadmPurger) Verify(email, prepared string) (string, error) {
+	switch p.verifyMode {
+	case VerifyModeMailboxStatus:
+		counts, err := p.doveadm.MailboxStatus(email)
+		if err != nil {
+			return "", err
+		}
+
+		return formatResidual(counts), nil
+	case VerifyModeHome:
+		checker, ok := p.doveadm.(HomeChecker)
+		if !ok {
+			return "", fmt.Errorf("home verification is not supported by %s", p.doveadm)
+		}
+
+		if prepared == "" {
+			return "", errors.New("home directory was not resolved before the purge")
+		}
+
+		exists, err := checker.HomeExists(prepared)
+		if err != nil || !exists {
+			return "", err
+		}
+
+		return "home=" + prepared, nil
+	default:
+		return "", nil
+	}
+}
+
+// Check verifies that doveadm can be used
+func (p *DoveadmPurger) Check() error {
+	if c, ok := p.doveadm.(interface{ Check() error }); ok {
+		return c.Check()
+	}
+
+	return nil
+}
+
+// Describe returns the doveadm client and verification mode
+func (p *DoveadmPurger) Describe() string {
+	if p.verifyMode == VerifyModeNone {
+		return fmt.Sprintf("doveadm (%s)", p.doveadm)
+	}
+
+	return fmt.Sprintf("doveadm (%s, verify %s)", p.doveadm, p.verifyMode)
+}
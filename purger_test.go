@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type DoveadmPurgerTestSuite struct {
+	suite.Suite
+}
+
+func (s *DoveadmPurgerTestSuite) SetupTest() {
+	logger = zap.NewNop()
+}
+
+// homeDoveadm is a doveadm client with home directories kept in memory
+type homeDoveadm struct {
+	homes map[string]string
+	gone  map[string]bool
+}
+
+func (d *homeDoveadm) Purge(ctx context.Context, email string) error {
+	d.gone[d.homes[email]] = true
+	delete(d.homes, email)
+	return nil
+}
+
+func (d *homeDoveadm) MailboxStatus(email string) (map[string]int, error) {
+	return nil, nil
+}
+
+func (d *homeDoveadm) UserHome(email string) (string, error) {
+	home, ok := d.homes[email]
+	if !ok {
+		return "", errors.New("user not found")
+	}
+	return home, nil
+}
+
+func (d *homeDoveadm) HomeExists(home string) (bool, error) {
+	return !d.gone[home], nil
+}
+
+func (d *homeDoveadm) String() string {
+	return "fake"
+}
+
+// fakeDoveadm writes a shell script printing output and returns a client executing it
+func (s *DoveadmPurgerTestSuite) fakeDoveadm(output string) *DoveadmExec {
+	path := filepath.Join(s.T().TempDir(), "doveadm")
+	s.Require().NoError(os.WriteFile(path, []byte("#!/bin/sh\necho '"+output+"'\n"), 0o700))
+	return NewDoveadmExec(path, false)
+}
+
+func (s *DoveadmPurgerTestSuite) TestPurge() {
+	// Use mock doveadm command for testing (just use 'echo' which exists on all systems)
+	purger := NewDoveadmPurger(NewDoveadmExec("/bin/echo", false), VerifyModeNone)
+
+	s.NoError(purger.Purge(context.Background(), "test@example.com"))
+}
+
+func (s *DoveadmPurgerTestSuite) TestPurge_CommandFails() {
+	purger := NewDoveadmPurger(NewDoveadmExec("/nonexistent/command", false), VerifyModeNone)
+
+	s.Error(purger.Purge(context.Background(), "test@example.com"))
+}
+
+func (s *DoveadmPurgerTestSuite) TestPurge_InvalidEmail() {
+	purger := NewDoveadmPurger(NewDoveadmExec("/bin/echo", false), VerifyModeNone)
+
+	s.ErrorIs(purger.Purge(context.Background(), "*@example.com"), ErrInvalidEmail)
+}
+
+func (s *DoveadmPurgerTestSuite) TestVerify_None() {
+	purger := NewDoveadmPurger(NewDoveadmExec("/nonexistent/command", false), VerifyModeNone)
+
+	residual, err := purger.Verify("test@example.com", "")
+	s.NoError(err)
+	s.Empty(residual)
+}
+
+func (s *DoveadmPurgerTestSuite) TestVerify_MailboxStatus() {
+	purger := NewDoveadmPurger(s.fakeDoveadm("INBOX messages=0"), VerifyModeMailboxStatus)
+
+	residual, err := purger.Verify("test@example.com", "")
+	s.NoError(err)
+	s.Empty(residual)
+
+	purger = NewDoveadmPurger(s.fakeDoveadm("INBOX messages=3"), VerifyModeMailboxStatus)
+
+	residual, err = purger.Verify("test@example.com", "")
+	s.NoError(err)
+	s.Equal("INBOX=3", residual)
+}
+
+func (s *DoveadmPurgerTestSuite) TestVerify_Home() {
+	home := s.T().TempDir()
+
+	// Home directory still exists
+	purger := NewDoveadmPurger(s.fakeDoveadm(home), VerifyModeHome)
+
+	prepared, err := purger.Prepare("test@example.com")
+	s.Require().NoError(err)
+	s.Equal(home, prepared)
+
+	residual, err := purger.Verify("test@example.com", prepared)
+	s.NoError(err)
+	s.Equal("home="+home, residual)
+
+	// Home directory is gone
+	residual, err = purger.Verify("test@example.com", filepath.Join(home, "nonexistent"))
+	s.NoError(err)
+	s.Empty(residual)
+
+	// Without a prepared home nothing can be checked
+	_, err = purger.Verify("test@example.com", "")
+	s.Error(err)
+}
+
+func (s *DoveadmPurgerTestSuite) TestVerify_HomeUserDeleted() {
+	home := s.T().TempDir()
+	deleted := filepath.Join(s.T().TempDir(), "deleted")
+
+	// The user lookup fails once the purge has run, as the user is deleted by then
+	path := filepath.Join(s.T().TempDir(), "doveadm")
+	script := "#!/bin/sh\n" +
+		"if [ \"$1\" = purge ]; then touch " + deleted + "; exit 0; fi\n" +
+		"if [ -e " + deleted + " ]; then echo 'user not found' >&2; exit 67; fi\n" +
+		"echo " + home + "\n"
+	s.Require().NoError(os.WriteFile(path, []byte(script), 0o700))
+	purger := NewDoveadmPurger(NewDoveadmExec(path, false), VerifyModeHome)
+
+	prepared, err := purger.Prepare("test@example.com")
+	s.Require().NoError(err)
+	s.Require().NoError(purger.Purge(context.Background(), "test@example.com"))
+
+	_, err = NewDoveadmExec(path, false).UserHome("test@example.com")
+	s.Require().Error(err)
+
+	// The home resolved before the purge is still checked
+	residual, err := purger.Verify("test@example.com", prepared)
+	s.NoError(err)
+	s.Equal("home="+home, residual)
+}
+
+func (s *DoveadmPurgerTestSuite) TestVerify_HomeChecker() {
+	doveadm := &homeDoveadm{homes: map[string]string{"test@example.com": "/var/vmail/example.com/test"}, gone: map[string]bool{}}
+	purger := NewDoveadmPurger(doveadm, VerifyModeHome)
+
+	prepared, err := purger.Prepare("test@example.com")
+	s.Require().NoError(err)
+	s.Equal("/var/vmail/example.com/test", prepared)
+
+	residual, err := purger.Verify("test@example.com", prepared)
+	s.NoError(err)
+	s.Equal("home=/var/vmail/example.com/test", residual)
+
+	s.Require().NoError(purger.Purge(context.Background(), "test@example.com"))
+	residual, err = purger.Verify("test@example.com", prepared)
+	s.NoError(err)
+	s.Empty(residual)
+}
+
+func (s *DoveadmPurgerTestSuite) TestPrepare() {
+	prepared, err := NewDoveadmPurger(s.fakeDoveadm("/var/vmail/example.com/test"), VerifyModeMailboxStatus).Prepare("test@example.com")
+	s.NoError(err)
+	s.Empty(prepared)
+
+	_, err = NewDoveadmPurger(NewDoveadmExec("/nonexistent/command", false), VerifyModeHome).Prepare("test@example.com")
+	s.Error(err)
+}
+
+func (s *DoveadmPurgerTestSuite) TestVerify_HomeRequiresHomeChecker() {
+	purger := NewDoveadmPurger(NewDoveadmHTTP("http://localhost", "", "", 0), VerifyModeHome)
+
+	_, err := purger.Prepare("test@example.com")
+	s.Error(err)
+
+	_, err = purger.Verify("test@example.com", "/var/vmail/example.com/test")
+	s.Error(err)
+}
+
+func (s *DoveadmPurgerTestSuite) TestCheck() {
+	s.NoError(NewDoveadmPurger(NewDoveadmExec("/bin/echo", false), VerifyModeNone).Check())
+	s.Error(NewDoveadmPurger(NewDoveadmExec("/nonexistent/command", false), VerifyModeNone).Check())
+
+	notExecutable := filepath.Join(s.T().TempDir(), "doveadm")
+	s.Require().NoError(os.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0o600))
+	s.ErrorContains(NewDoveadmPurger(NewDoveadmExec(notExecutable, false), VerifyModeNone).Check(), "not executable")
+}
+
+func (s *DoveadmPurgerTestSuite) TestDescribe() {
+	s.Equal("doveadm (exec sudo /usr/bin/doveadm)", NewDoveadmPurger(NewDoveadmExec("/usr/bin/doveadm", true), VerifyModeNone).Describe())
+	s.Equal("doveadm (http http://dovecot/doveadm/v1, verify mailbox-status)", NewDoveadmPurger(NewDoveadmHTTP("http://dovecot/doveadm/v1", "", "", 0), VerifyModeMailboxStatus).Describe())
+}
+
+func TestDoveadmPurgerTestSuite(t *testing.T) {
+	suite.Run(t, new(DoveadmPurgerTestSuite))
+}
+
+func TestBuildPurgers(t *testing.T) {
+	logger = zap.NewNop()
+	doveadm := NewDoveadmExec("/usr/bin/doveadm", false)
+
+	purgers, err := BuildPurgers(&Config{PurgeBackend: PurgeBackendDoveadm, DomainPurgeBackends: map[string]string{"Example.org": PurgeBackendDoveadm}}, doveadm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := purgers.For("user@example.org").(*DoveadmPurger); !ok {
+		t.Error("expected doveadm backend for example.org")
+	}
+	if purgers.For("user@example.org") != purgers.For("user@example.com") {
+		t.Error("expected backends to be shared")
+	}
+
+	purgers, err = BuildPurgers(&Config{
+		PurgeBackend:        PurgeBackendDoveadm,
+		DomainPurgeBackends: map[string]string{"example.org": PurgeBackendMaildir},
+		MaildirPathTemplate: "/var/vmail/%d/%n",
+		MaildirRoot:         "/var/vmail",
+	}, doveadm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := purgers.For("user@example.org").(*MaildirPurger); !ok {
+		t.Error("expected maildir backend for example.org")
+	}
+
+	purgers, err = BuildPurgers(&Config{
+		PurgeBackend:        PurgeBackendDoveadm,
+		DomainPurgeBackends: map[string]string{"Bücher.example": PurgeBackendMaildir},
+		MaildirPathTemplate: "/var/vmail/%d/%n",
+		MaildirRoot:         "/var/vmail",
+	}, doveadm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := purgers.For("user@xn--bcher-kva.example").(*MaildirPurger); !ok {
+		t.Error("expected maildir backend for the internationalized domain")
+	}
+
+	if _, err := BuildPurgers(&Config{PurgeBackend: PurgeBackendMaildir, MaildirPathTemplate: "relative/%n", MaildirRoot: "/var/vmail"}, doveadm); err == nil {
+		t.Error("expected error for invalid maildir configuration")
+	}
+	if _, err := BuildPurgers(&Config{PurgeBackend: "unknown"}, doveadm); err == nil {
+		t.Error("expected error for unknown default backend")
+	}
+	if _, err := BuildPurgers(&Config{PurgeBackend: PurgeBackendDoveadm, DomainPurgeBackends: map[string]string{"example.org": "unknown"}}, doveadm); err == nil {
+		t.Error("expected error for unknown domain backend")
+	}
+	if _, err := BuildPurgers(&Config{PurgeBackend: PurgeBackendDoveadm, DomainPurgeBackends: map[string]string{"exa mple.org": PurgeBackendDoveadm}}, doveadm); err == nil {
+		t.Error("expected error for invalid domain")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Readiness check results
+const (
+	CheckStatusOK   = "ok"
+	CheckStatusFail = "fail"
+)
+
+// ReadinessCheck is a dependency that must work for the janitor to be ready
+type ReadinessCheck struct {
+	Name   string
+	Target string
+	Check  func() error
+}
+
+// CheckResult is the outcome of a single readiness check
+type CheckResult struct {
+	Name     string `json:"name"`
+	Target   string `json:"target,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// ReadinessReport is the response of the readiness endpoint
+type ReadinessReport struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// BuildReadinessChecks returns the checks for the database, all purge backends and the worker
+func BuildReadinessChecks(db *Database, purgers *Purgers, worker *Worker) []ReadinessCheck {
+	checks := []ReadinessCheck{{Name: "database", Target: db.filePath, Check: db.Check}}
+	checks = append(checks, purgers.Checks()...)
+	checks = append(checks, ReadinessCheck{Name: "worker", Check: worker.CheckTick})
+
+	return checks
+}
+
+// handleReady runs all readiness checks and reports their results
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	report := ReadinessReport{Status: CheckStatusOK, Checks: []CheckResult{}}
+
+	for _, check := range s.readiness {
+		start := time.Now()
+		err := check.Check()
+
+		result := CheckResult{
+			Name:     check.Name,
+			Target:   check.Target,
+			Status:   CheckStatusOK,
+			Duration: time.Since(start).Round(time.Millisecond).String(),
+		}
+		if err != nil {
+			result.Status = CheckStatusFail
+			result.Error = err.Error()
+			report.Status = CheckStatusFail
+		}
+
+		report.Checks = append(report.Checks, result)
+	}
+
+	status := http.StatusOK
+	if report.Status != CheckStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, report)
+}
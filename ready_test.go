@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type ReadyTestSuite struct {
+	suite.Suite
+	db *Database
+}
+
+func (s *ReadyTestSuite) SetupTest() {
+	logger = zap.NewNop()
+
+	tempDir := s.T().TempDir()
+
+	var err error
+	s.db, err = NewDatabase(filepath.Join(tempDir, "mailboxes.csv"), filepath.Join(tempDir, "domain_holds.csv"), NewEmailNormalizer(true))
+	s.Require().NoError(err)
+}
+
+func (s *ReadyTestSuite) ready(checks []ReadinessCheck) (int, ReadinessReport) {
+	server := NewServer("", s.db, NewEmailNormalizer(true), userliRoutes("test-secret"), ServerOptions{Readiness: checks})
+	server.RegisterRoutes()
+
+	req := httptest.NewRequest("GET", "/ready", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	var report ReadinessReport
+	s.NoError(json.NewDecoder(w.Body).Decode(&report))
+	return w.Code, report
+}
+
+func (s *ReadyTestSuite) TestReady() {
+	code, report := s.ready([]ReadinessCheck{
+		{Name: "database", Target: "mailboxes.csv", Check: s.db.Check},
+		{Name: "worker", Check: func() error { return nil }},
+	})
+
+	s.Equal(http.StatusOK, code)
+	s.Equal(CheckStatusOK, report.Status)
+	s.Require().Len(report.Checks, 2)
+	s.Equal("database", report.Checks[0].Name)
+	s.Equal("mailboxes.csv", report.Checks[0].Target)
+	s.Equal(CheckStatusOK, report.Checks[0].Status)
+}
+
+func (s *ReadyTestSuite) TestReady_Failing() {
+	code, report := s.ready([]ReadinessCheck{
+		{Name: "database", Check: s.db.Check},
+		{Name: "worker", Check: func() error { return errors.New("worker has not ticked yet") }},
+	})
+
+	s.Equal(http.StatusServiceUnavailable, code)
+	s.Equal(CheckStatusFail, report.Status)
+	s.Require().Len(report.Checks, 2)
+	s.Equal(CheckStatusOK, report.Checks[0].Status)
+	s.Equal(CheckStatusFail, report.Checks[1].Status)
+	s.Equal("worker has not ticked yet", report.Checks[1].Error)
+}
+
+func (s *ReadyTestSuite) TestBuildReadinessChecks() {
+	maildir, err := NewMaildirPurger("/var/vmail/%d/%n", "/var/vmail", "", 0)
+	s.Require().NoError(err)
+	doveadm := NewDoveadmPurger(NewDoveadmExec("/bin/echo", false), VerifyModeNone)
+	purgers := NewPurgers(doveadm, map[string]Purger{"example.org": maildir, "example.net": doveadm})
+	worker := NewWorker(s.db, purgers, 0, 0, NewEmailNormalizer(true), WorkerOptions{})
+
+	checks := BuildReadinessChecks(s.db, purgers, worker)
+
+	s.Require().Len(checks, 4)
+	s.Equal("database", checks[0].Name)
+	s.Equal("purge_backend", checks[1].Name)
+	s.Equal(doveadm.Describe(), checks[1].Target)
+	s.Equal(maildir.Describe(), checks[2].Target)
+	s.Equal("worker", checks[3].Name)
+}
+
+func TestReadyTestSuite(t *testing.T) {
+	suite.Run(t, new(ReadyTestSuite))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RequestIDHeader carries the correlation ID of a request
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the length of request IDs accepted from clients
+const maxRequestIDLength = 128
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	requestLoggerKey
+)
+
+// RequestIDMiddleware takes the request ID from the client or assigns a new one,
+// returns it in the response and attaches it to the logger of the request
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		ctx = context.WithValue(ctx, requestLoggerKey, logger.With(zap.String("requestID", id)))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID reports whether a client supplied request ID is safe to log and return
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestID returns the ID of the request, empty outside of a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// requestLogger returns the logger with the request ID, the global logger outside of a request
+func requestLogger(ctx context.Context) *zap.Logger {
+	if l, ok := ctx.Value(requestLoggerKey).(*zap.Logger); ok {
+		return l
+	}
+
+	return logger
+}
+
+// requestPathField returns the route pattern of the request as log field. It keeps
+// email addresses like in /admin/mailboxes/{email} out of the log, the path is
+// only logged as it is for requests without route.
+func requestPathField(r *http.Request) zap.Field {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		return zap.String("path", rctx.RoutePattern())
+	}
+
+	return pathField("path", r.URL.Path)
+}
+
+// AccessLogMiddleware logs one line per request. Probes and metrics scrapes are
+// logged at debug level.
+func (s *Server) AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := zapcore.InfoLevel
+		switch r.URL.Path {
+		case "/health", "/ready", "/metrics":
+			level = zapcore.DebugLevel
+		}
+
+		requestLogger(r.Context()).Log(level, "HTTP request",
+			zap.String("method", r.Method),
+			requestPathField(r),
+			zap.Int("status", status),
+			zap.Int("bytes", ww.BytesWritten()),
+			zap.Duration("duration", time.Since(start)),
+			zap.Stringer("clientIP", s.sources.ClientIP(r)),
+			zap.String("remoteAddr", r.RemoteAddr),
+			zap.String("userAgent", r.UserAgent()))
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestValidRequestID(t *testing.T) {
+	assert.True(t, validRequestID("abc-123"))
+	assert.True(t, validRequestID("0f8fad5b-d9cb-469f-a165-70867728950e"))
+	assert.True(t, validRequestID("trace:span.1_2"))
+	assert.False(t, validRequestID(""))
+	assert.False(t, validRequestID("with space"))
+	assert.False(t, validRequestID("line\nbreak"))
+	assert.False(t, validRequestID(strings.Repeat("a", maxRequestIDLength+1)))
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	logger = zap.NewNop()
+
+	var seen string
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"propagated", "userli-42", true},
+		{"generated", "", false},
+		{"invalid replaced", "bad id\r\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/health", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, seen, w.Header().Get(RequestIDHeader))
+			if tt.keep {
+				assert.Equal(t, tt.incoming, seen)
+			} else {
+				assert.Len(t, seen, 32)
+				_, err := hex.DecodeString(seen)
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger = zap.New(core)
+	defer func() { logger = zap.NewNop() }()
+
+	db, err := NewDatabase(t.TempDir()+"/mailboxes.csv", t.TempDir()+"/domain_holds.csv", NewEmailNormalizer(true))
+	require.NoError(t, err)
+
+	server := NewServer("admin-token", db, NewEmailNormalizer(true), userliRoutes("secret"), ServerOptions{})
+	server.RegisterRoutes()
+
+	body := `{"type":"user.deleted","data":{"email":"user@example.org"}}`
+	req := httptest.NewRequest("POST", "/userli", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DefaultSignatureHeader, hex.EncodeToString(sign("secret", body)))
+	req.Header.Set(RequestIDHeader, "userli-42")
+	req.Header.Set("User-Agent", "userli")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The request ID is attached to the log lines of the handler
+	added := logs.FilterMessage("Mailbox added to database").All()
+	require.Len(t, added, 1)
+	assert.Equal(t, "userli-42", added[0].ContextMap()["requestID"])
+
+	access := logs.FilterMessage("HTTP request").All()
+	require.Len(t, access, 1)
+	assert.Equal(t, zapcore.InfoLevel, access[0].Level)
+	fields := access[0].ContextMap()
+	assert.Equal(t, "userli-42", fields["requestID"])
+	assert.Equal(t, "POST", fields["method"])
+	assert.Equal(t, "/userli", fields["path"])
+	assert.Equal(t, int64(http.StatusOK), fields["status"])
+	assert.Equal(t, "192.0.2.1", fields["clientIP"])
+	assert.Equal(t, "userli", fields["userAgent"])
+
+	// Probes are logged at debug level
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	access = logs.FilterMessage("HTTP request").All()
+	require.Len(t, access, 2)
+	assert.Equal(t, zapcore.DebugLevel, access[1].Level)
+	assert.Equal(t, "/health", access[1].ContextMap()["path"])
+	assert.NotEmpty(t, w.Header().Get(RequestIDHeader))
+
+	// The route pattern is logged instead of the email address in the path
+	req = httptest.NewRequest("DELETE", "/admin/mailboxes/user@example.org", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	access = logs.FilterMessage("HTTP request").All()
+	require.Len(t, access, 3)
+	assert.Equal(t, "/admin/mailboxes/{email}", access[2].ContextMap()["path"])
+
+	// Paths without route are only logged with plain email addresses
+	require.NoError(t, SetLogEmails(LogEmailsMask, ""))
+	defer func() { logEmails = &emailPseudonymizer{mode: LogEmailsPlain} }()
+	server.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/user@example.org", nil))
+	access = logs.FilterMessage("HTTP request").All()
+	require.Len(t, access, 4)
+	assert.NotContains(t, access[3].ContextMap(), "path")
+}
+
+func TestRequestPathField(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger = zap.New(core)
+	require.NoError(t, SetLogEmails(LogEmailsMask, ""))
+	defer func() {
+		logger = zap.NewNop()
+		logEmails = &emailPseudonymizer{mode: LogEmailsPlain}
+	}()
+
+	db, err := NewDatabase(t.TempDir()+"/mailboxes.csv", t.TempDir()+"/domain_holds.csv", NewEmailNormalizer(true))
+	require.NoError(t, err)
+
+	server := NewServer("admin-token", db, NewEmailNormalizer(true), userliRoutes("secret"), ServerOptions{MaxBodySize: 16})
+	server.RegisterRoutes()
+
+	// Rejected by the admin token
+	server.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/admin/mailboxes/user@example.org", nil))
+
+	// Rejected by the body limits
+	for body, contentType := range map[string]string{`{"reason":"legal hold"}`: "application/json", "{}": "text/plain"} {
+		req := httptest.NewRequest("PUT", "/admin/holds/mailboxes/user@example.org", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer admin-token")
+		req.Header.Set("Content-Type", contentType)
+		server.router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	require.NotEmpty(t, logs.FilterMessage("Invalid admin token").All())
+	require.NotEmpty(t, logs.FilterMessage("Request body too large").All())
+	require.NotEmpty(t, logs.FilterMessage("Unsupported content type").All())
+	for _, entry := range logs.All() {
+		for key, value := range entry.ContextMap() {
+			assert.NotContains(t, fmt.Sprint(value), "user@example.org", "%s of %q", key, entry.Message)
+		}
+	}
+}
@@ -1,13 +1,12 @@
 package main
 
 import (
-	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
-	"io"
+	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
@@ -15,31 +14,100 @@ import (
 
 // Server handles HTTP requests and webhook events
 type Server struct {
-	router        *chi.Mux
-	webhookSecret string
-	db            *Database
+	router      *chi.Mux
+	db          *Database
+	protected   *ProtectedList
+	domains     *DomainFilter
+	normalizer  *EmailNormalizer
+	adminToken  string
+	notifier    *Notifier
+	readiness   []ReadinessCheck
+	worker      *Worker
+	sources     *SourceFilter
+	maxBodySize int64
+	webhooks    []WebhookRoute
+
+	// requireClientCert is set when serving mutual TLS
+	requireClientCert bool
+
+	// foreignDomainEvents counts events ignored because of the domain filter
+	foreignDomainEvents atomic.Uint64
 }
 
-// NewServer creates a new HTTP server instance
-func NewServer(webhookSecret string, db *Database) *Server {
+// ServerOptions holds the optional collaborators of a Server, nil fields are disabled
+type ServerOptions struct {
+	Protected *ProtectedList
+	Domains   *DomainFilter
+	Notifier  *Notifier
+	Readiness []ReadinessCheck
+	Worker    *Worker
+	Sources   *SourceFilter
+
+	// MaxBodySize limits request bodies in bytes, defaultMaxBodySize if zero
+	MaxBodySize int64
+}
+
+// NewServer creates a new HTTP server instance serving the webhook routes built
+// by BuildWebhookRoutes
+func NewServer(adminToken string, db *Database, normalizer *EmailNormalizer, webhooks []WebhookRoute, opts ServerOptions) *Server {
+	maxBodySize := opts.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = defaultMaxBodySize
+	}
+
 	return &Server{
-		router:        chi.NewRouter(),
-		webhookSecret: webhookSecret,
-		db:            db,
+		router:      chi.NewRouter(),
+		adminToken:  adminToken,
+		db:          db,
+		protected:   opts.Protected,
+		domains:     opts.Domains,
+		normalizer:  normalizer,
+		notifier:    opts.Notifier,
+		readiness:   opts.Readiness,
+		worker:      opts.Worker,
+		sources:     opts.Sources,
+		maxBodySize: maxBodySize,
+		webhooks:    webhooks,
 	}
 }
 
-// Start starts the HTTP server
-func (s *Server) Start(addr string) error {
+// Start serves HTTP on the listener, TLS if tlsConfig is set
+func (s *Server) Start(listener net.Listener, tlsConfig *tls.Config) error {
+	s.requireClientCert = tlsConfig != nil && tlsConfig.ClientAuth != tls.NoClientCert
 	s.RegisterRoutes()
-	logger.Info("Starting HTTP server", zap.String("address", addr))
-	return http.ListenAndServe(addr, s.router)
+
+	server := &http.Server{
+		Handler:           s.router,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          zap.NewStdLog(logger),
+	}
+
+	if tlsConfig != nil {
+		logger.Info("Starting HTTPS server", zap.String("address", listener.Addr().String()), zap.Bool("clientCertRequired", s.requireClientCert))
+		return server.ServeTLS(listener, "", "")
+	}
+
+	logger.Info("Starting HTTP server", zap.String("address", listener.Addr().String()))
+	return server.Serve(listener)
 }
 
 // RegisterRoutes registers all HTTP routes
 func (s *Server) RegisterRoutes() {
+	s.router.Use(RequestIDMiddleware, s.AccessLogMiddleware)
+
 	s.router.Get("/health", s.handleHealth)
-	s.router.With(s.AuthMiddleware).Post("/userli", s.handleUserliEvent)
+	s.router.Get("/ready", s.handleReady)
+	s.router.Get("/metrics", s.handleMetrics)
+	for _, route := range s.webhooks {
+		// Tracing follows the signature check, so unauthenticated requests cannot continue a trace
+		s.router.With(s.SourceMiddleware, s.ClientCertMiddleware, s.BodyMiddleware, route.Verifier.Middleware, s.TracingMiddleware).Post(route.Path, s.handleUserliEvent)
+	}
+
+	// The admin API is only available with a configured token
+	if s.adminToken != "" {
+		s.router.Route("/admin", s.registerAdminRoutes)
+	}
 }
 
 // handleHealth returns a simple health check response
@@ -50,20 +118,20 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 
 // handleUserliEvent processes incoming webhook events from userli
 func (s *Server) handleUserliEvent(w http.ResponseWriter, r *http.Request) {
-	logger.Info("Userli event received")
+	requestLogger(r.Context()).Info("Userli event received", requestPathField(r))
 
 	var event UserEvent
-	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-		logger.Error("Failed to decode event", zap.Error(err))
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(r.Body, &event); err != nil {
+		requestLogger(r.Context()).Error("Failed to decode event", zap.Error(err))
+		writeBodyError(w, err)
 		return
 	}
 
 	switch event.Type {
 	case EventTypeUserDeleted:
-		s.handleUserDeleted(event)
+		s.handleUserDeleted(r.Context(), event)
 	default:
-		logger.Warn("Unknown event type received", zap.String("type", event.Type))
+		requestLogger(r.Context()).Warn("Unknown event type received", zap.String("type", event.Type))
 		http.Error(w, "Unknown event type", http.StatusBadRequest)
 		return
 	}
@@ -72,61 +140,42 @@ func (s *Server) handleUserliEvent(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleUserDeleted processes user deletion events
-func (s *Server) handleUserDeleted(event UserEvent) {
+func (s *Server) handleUserDeleted(ctx context.Context, event UserEvent) {
+	log := requestLogger(ctx)
 	email := event.Data.Email
-	logger.Info("User deleted event received", zap.String("email", email))
+	log.Info("User deleted event received", emailField(email))
 
-	// Validate email before adding to database (defense in depth)
-	if err := validateEmail(email); err != nil {
-		logger.Error("Invalid email address rejected",
-			zap.String("email", email),
+	// Normalize and validate email before adding to database (defense in depth)
+	email, err := s.normalizer.Normalize(email)
+	if err != nil {
+		log.Error("Invalid email address rejected",
+			emailField(event.Data.Email),
 			zap.Error(err))
 		return
 	}
 
-	if err := s.db.AddMailbox(email); err != nil {
-		logger.Error("Failed to add mailbox to database",
-			zap.String("email", email),
-			zap.Error(err))
+	// Events for domains hosted on other clusters are acknowledged but not queued
+	if !s.domains.Allowed(email) {
+		count := s.foreignDomainEvents.Add(1)
+		log.Info("Ignoring event for foreign domain",
+			emailField(email),
+			zap.String("domain", emailDomain(email)),
+			zap.Uint64("ignoredTotal", count))
 		return
 	}
 
-	logger.Info("Mailbox added to purge queue", zap.String("email", email))
-}
+	if pattern, ok := s.protected.Match(email); ok {
+		logProtectedBlocked(log, email, pattern, "webhook")
+		return
+	}
+
+	if err := s.db.AddMailbox(ctx, email); err != nil {
+		log.Error("Failed to add mailbox to database",
+			emailField(email),
+			zap.Error(err))
+		return
+	}
 
-// AuthMiddleware verifies webhook signatures using HMAC SHA256
-func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		signature := r.Header.Get("X-Webhook-Signature")
-		if signature == "" {
-			logger.Warn("Missing webhook signature")
-			http.Error(w, "Missing signature header", http.StatusUnauthorized)
-			return
-		}
-
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			logger.Error("Failed to read request body", zap.Error(err))
-			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
-			return
-		}
-		defer r.Body.Close()
-
-		// Restore body for next handler
-		r.Body = io.NopCloser(bytes.NewBuffer(body))
-
-		// Compute expected signature
-		mac := hmac.New(sha256.New, []byte(s.webhookSecret))
-		mac.Write(body)
-		expectedSignature := hex.EncodeToString(mac.Sum(nil))
-
-		// Compare signatures
-		if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
-			logger.Warn("Invalid webhook signature")
-			http.Error(w, "Invalid signature", http.StatusUnauthorized)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
+	log.Info("Mailbox added to purge queue", emailField(email))
+	s.notifier.Notify(EventTypeMailboxQueued, MailboxEventData{Email: email})
 }
@@ -6,10 +6,12 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -18,9 +20,10 @@ import (
 
 type ServerTestSuite struct {
 	suite.Suite
-	server   *Server
-	db       *Database
-	tempFile string
+	server    *Server
+	db        *Database
+	tempFile  string
+	holdsFile string
 }
 
 func (s *ServerTestSuite) SetupTest() {
@@ -30,19 +33,28 @@ func (s *ServerTestSuite) SetupTest() {
 	// Create test database
 	tempDir := os.TempDir()
 	s.tempFile = filepath.Join(tempDir, "test_server_mailboxes.csv")
+	s.holdsFile = filepath.Join(tempDir, "test_server_domain_holds.csv")
 	os.Remove(s.tempFile) // Ensure clean state
+	os.Remove(s.holdsFile)
 
 	var err error
-	s.db, err = NewDatabase(s.tempFile)
+	s.db, err = NewDatabase(s.tempFile, s.holdsFile, NewEmailNormalizer(true))
+	s.Require().NoError(err)
+
+	protected, err := NewProtectedList([]string{"postmaster@", "@protected.org"})
+	s.Require().NoError(err)
+
+	domains, err := NewDomainFilter([]string{"example.com", "protected.org"})
 	s.Require().NoError(err)
 
 	// Create server
-	s.server = NewServer("test-secret", s.db)
+	s.server = NewServer("admin-token", s.db, NewEmailNormalizer(true), userliRoutes("test-secret"), ServerOptions{Protected: protected, Domains: domains})
 }
 
 func (s *ServerTestSuite) TearDownTest() {
 	s.db.Close()
 	os.Remove(s.tempFile)
+	os.Remove(s.holdsFile)
 }
 
 func (s *ServerTestSuite) TestHandleUserliEvent_InvalidBody() {
@@ -88,6 +100,26 @@ func (s *ServerTestSuite) TestHandleUserliEvent_UserDeleted() {
 	s.Equal("test@example.com", mailboxes[0].Email)
 }
 
+func (s *ServerTestSuite) TestHandleUserliEvent_UserDeleted_Normalized() {
+	event := UserEvent{
+		Type: EventTypeUserDeleted,
+	}
+	event.Data.Email = "Test@EXAMPLE.com"
+	jsonData, err := json.Marshal(event)
+	s.NoError(err)
+
+	req := httptest.NewRequest("POST", "/userli", bytes.NewBuffer(jsonData))
+	w := httptest.NewRecorder()
+
+	s.server.handleUserliEvent(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	mailboxes, err := s.db.GetDueMailboxes(0)
+	s.NoError(err)
+	s.Len(mailboxes, 1)
+	s.Equal("test@example.com", mailboxes[0].Email)
+}
+
 func (s *ServerTestSuite) TestHandleUserliEvent_UserDeleted_InvalidEmail() {
 	testCases := []struct {
 		name  string
@@ -122,6 +154,49 @@ func (s *ServerTestSuite) TestHandleUserliEvent_UserDeleted_InvalidEmail() {
 	}
 }
 
+func (s *ServerTestSuite) TestHandleUserliEvent_UserDeleted_ProtectedEmail() {
+	for _, email := range []string{"postmaster@example.com", "user@protected.org"} {
+		event := UserEvent{
+			Type: EventTypeUserDeleted,
+		}
+		event.Data.Email = email
+		jsonData, err := json.Marshal(event)
+		s.NoError(err)
+
+		req := httptest.NewRequest("POST", "/userli", bytes.NewBuffer(jsonData))
+		w := httptest.NewRecorder()
+
+		s.server.handleUserliEvent(w, req)
+		s.Equal(http.StatusOK, w.Code)
+	}
+
+	// Verify protected mailboxes were NOT added to database
+	mailboxes, err := s.db.GetDueMailboxes(0)
+	s.NoError(err)
+	s.Empty(mailboxes)
+}
+
+func (s *ServerTestSuite) TestHandleUserliEvent_UserDeleted_ForeignDomain() {
+	event := UserEvent{
+		Type: EventTypeUserDeleted,
+	}
+	event.Data.Email = "user@example.net"
+	jsonData, err := json.Marshal(event)
+	s.NoError(err)
+
+	req := httptest.NewRequest("POST", "/userli", bytes.NewBuffer(jsonData))
+	w := httptest.NewRecorder()
+
+	s.server.handleUserliEvent(w, req)
+	// Event is acknowledged but not queued
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal(uint64(1), s.server.foreignDomainEvents.Load())
+
+	mailboxes, err := s.db.GetDueMailboxes(0)
+	s.NoError(err)
+	s.Empty(mailboxes)
+}
+
 func (s *ServerTestSuite) TestAuthMiddleware_ValidSignature() {
 	payload := []byte(`{"type":"user.deleted","data":{"email":"test@example.com"}}`)
 	mac := hmac.New(sha256.New, []byte("test-secret"))
@@ -136,7 +211,7 @@ func (s *ServerTestSuite) TestAuthMiddleware_ValidSignature() {
 	})
 
 	rr := httptest.NewRecorder()
-	s.server.AuthMiddleware(handler).ServeHTTP(rr, req)
+	s.server.webhooks[0].Verifier.Middleware(handler).ServeHTTP(rr, req)
 
 	s.Equal(http.StatusOK, rr.Code)
 }
@@ -152,7 +227,7 @@ func (s *ServerTestSuite) TestAuthMiddleware_InvalidSignature() {
 	})
 
 	rr := httptest.NewRecorder()
-	s.server.AuthMiddleware(handler).ServeHTTP(rr, req)
+	s.server.webhooks[0].Verifier.Middleware(handler).ServeHTTP(rr, req)
 
 	s.Equal(http.StatusUnauthorized, rr.Code)
 }
@@ -167,11 +242,86 @@ func (s *ServerTestSuite) TestAuthMiddleware_MissingSignature() {
 	})
 
 	rr := httptest.NewRecorder()
-	s.server.AuthMiddleware(handler).ServeHTTP(rr, req)
+	s.server.webhooks[0].Verifier.Middleware(handler).ServeHTTP(rr, req)
 
 	s.Equal(http.StatusUnauthorized, rr.Code)
 }
 
+func (s *ServerTestSuite) TestHandleUserliEvent_StrictDecoding() {
+	for _, body := range []string{
+		`{"type":"user.deleted","data":{"email":"test@example.com","name":"Test"}}`,
+		`{"type":"user.deleted","data":{"email":"test@example.com"},"extra":true}`,
+		`{"type":"user.deleted","data":{"email":"test@example.com"}}{"type":"user.deleted"}`,
+		`{"type":"user.deleted","data":{"email":"test@example.com"}} trailing`,
+	} {
+		req := httptest.NewRequest("POST", "/userli", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+
+		s.server.handleUserliEvent(w, req)
+		s.Equal(http.StatusBadRequest, w.Code, body)
+	}
+
+	// Trailing whitespace is fine
+	req := httptest.NewRequest("POST", "/userli", bytes.NewBufferString(`{"type":"user.deleted","data":{"email":"test@example.com"}}`+"\n"))
+	w := httptest.NewRecorder()
+	s.server.handleUserliEvent(w, req)
+	s.Equal(http.StatusOK, w.Code)
+}
+
+func (s *ServerTestSuite) TestHandleUserliEvent_UserliPayload() {
+	s.server.RegisterRoutes()
+
+	// Every field userli sends today, in its timestamp format
+	body := `{"type":"user.deleted","timestamp":"2025-01-15T10:30:00+00:00","data":{"email":"test@example.com"}}`
+	req := httptest.NewRequest("POST", "/userli", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	mac := hmac.New(sha256.New, []byte("test-secret"))
+	mac.Write([]byte(body))
+	req.Header.Set("X-Webhook-Signature", hex.EncodeToString(mac.Sum(nil)))
+
+	w := httptest.NewRecorder()
+	s.server.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	mailboxes, err := s.db.GetDueMailboxes(0)
+	s.NoError(err)
+	s.Len(mailboxes, 1)
+	s.Equal("test@example.com", mailboxes[0].Email)
+}
+
+func (s *ServerTestSuite) TestBodyMiddleware() {
+	s.server.maxBodySize = 128
+	s.server.RegisterRoutes()
+
+	send := func(body io.Reader, contentType string) int {
+		req := httptest.NewRequest("POST", "/userli", body)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		payload, _ := io.ReadAll(req.Body)
+		req.Body = io.NopCloser(bytes.NewReader(payload))
+		mac := hmac.New(sha256.New, []byte("test-secret"))
+		mac.Write(payload)
+		req.Header.Set("X-Webhook-Signature", hex.EncodeToString(mac.Sum(nil)))
+
+		w := httptest.NewRecorder()
+		s.server.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	payload := `{"type":"user.deleted","data":{"email":"test@example.com"}}`
+	s.Equal(http.StatusOK, send(bytes.NewBufferString(payload), "application/json"))
+	s.Equal(http.StatusOK, send(bytes.NewBufferString(payload), "application/json; charset=utf-8"))
+	s.Equal(http.StatusUnsupportedMediaType, send(bytes.NewBufferString(payload), ""))
+	s.Equal(http.StatusUnsupportedMediaType, send(bytes.NewBufferString(payload), "text/plain"))
+
+	large := `{"type":"user.deleted","data":{"email":"` + strings.Repeat("a", 200) + `@example.com"}}`
+	s.Equal(http.StatusRequestEntityTooLarge, send(bytes.NewBufferString(large), "application/json"))
+
+	// Without Content-Length the body is cut off while reading
+	s.Equal(http.StatusRequestEntityTooLarge, send(io.MultiReader(strings.NewReader(large)), "application/json"))
+}
+
 func TestServerTestSuite(t *testing.T) {
 	suite.Run(t, new(ServerTestSuite))
 }
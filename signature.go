@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Supported webhook signature schemes, all based on HMAC SHA256
+const (
+	// SignatureSchemeHex is a bare hex digest of the body, as sent by userli
+	SignatureSchemeHex = "hex"
+	// SignatureSchemeSHA256 is a hex digest with a sha256= prefix
+	SignatureSchemeSHA256 = "sha256"
+	// SignatureSchemeBase64 is a base64 encoded digest of the body
+	SignatureSchemeBase64 = "base64"
+	// SignatureSchemeTimestamped is t=<unix>,v1=<hex> signed over "<unix>.<body>"
+	SignatureSchemeTimestamped = "timestamped"
+)
+
+// DefaultSignatureHeader is the header carrying the signature unless configured otherwise
+const DefaultSignatureHeader = "X-Webhook-Signature"
+
+// webhookRoutePrefix is the path prefix of additional webhook routes
+const webhookRoutePrefix = "/webhooks/"
+
+var errInvalidSignature = errors.New("invalid signature")
+
+// SignatureVerifier verifies the signature of webhook requests
+type SignatureVerifier struct {
+	secret    []byte
+	scheme    string
+	header    string
+	tolerance time.Duration
+}
+
+// NewSignatureVerifier creates a verifier for the scheme, reading the signature from
+// header. Timestamped signatures older or newer than the tolerance are rejected.
+func NewSignatureVerifier(secret, scheme, header string, tolerance time.Duration) (*SignatureVerifier, error) {
+	switch scheme {
+	case SignatureSchemeHex, SignatureSchemeSHA256, SignatureSchemeBase64, SignatureSchemeTimestamped:
+	default:
+		return nil, fmt.Errorf("unknown signature scheme %q", scheme)
+	}
+
+	if secret == "" {
+		return nil, errors.New("secret is required")
+	}
+
+	if header == "" {
+		header = DefaultSignatureHeader
+	}
+
+	return &SignatureVerifier{secret: []byte(secret), scheme: scheme, header: header, tolerance: tolerance}, nil
+}
+
+// Verify checks the signature against the body
+func (v *SignatureVerifier) Verify(signature string, body []byte) error {
+	signature = strings.TrimSpace(signature)
+
+	switch v.scheme {
+	case SignatureSchemeSHA256:
+		digest, ok := strings.CutPrefix(signature, "sha256=")
+		if !ok {
+			return errors.New("missing sha256= prefix")
+		}
+		return v.compareHex(digest, body)
+	case SignatureSchemeBase64:
+		return v.compareBase64(signature, body)
+	case SignatureSchemeTimestamped:
+		return v.verifyTimestamped(signature, body)
+	default:
+		return v.compareHex(signature, body)
+	}
+}
+
+// mac returns the HMAC SHA256 of the payload
+func (v *SignatureVerifier) mac(payload []byte) []byte {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// compareHex compares a hex encoded digest
+func (v *SignatureVerifier) compareHex(digest string, payload []byte) error {
+	decoded, err := hex.DecodeString(digest)
+	if err != nil || !hmac.Equal(decoded, v.mac(payload)) {
+		return errInvalidSignature
+	}
+
+	return nil
+}
+
+// compareBase64 compares a digest in standard or URL-safe base64, with or without padding
+func (v *SignatureVerifier) compareBase64(digest string, payload []byte) error {
+	expected := v.mac(payload)
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := encoding.DecodeString(digest); err == nil {
+			if hmac.Equal(decoded, expected) {
+				return nil
+			}
+			break
+		}
+	}
+
+	return errInvalidSignature
+}
+
+// verifyTimestamped checks t=<unix>,v1=<hex> with one or more v1 digests, e.g. during secret rotation
+func (v *SignatureVerifier) verifyTimestamped(signature string, body []byte) error {
+	var timestamp string
+	var digests []string
+	for _, part := range strings.Split(signature, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			digests = append(digests, value)
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid timestamp")
+	}
+
+	if len(digests) == 0 {
+		return errors.New("missing v1 signature")
+	}
+
+	if v.tolerance > 0 {
+		if age := time.Since(time.Unix(unix, 0)); age > v.tolerance || age < -v.tolerance {
+			return fmt.Errorf("timestamp outside tolerance of %s", v.tolerance)
+		}
+	}
+
+	payload := append([]byte(timestamp+"."), body...)
+	for _, digest := range digests {
+		if v.compareHex(digest, payload) == nil {
+			return nil
+		}
+	}
+
+	return errInvalidSignature
+}
+
+// Middleware rejects requests without a valid signature
+func (v *SignatureVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature := r.Header.Get(v.header)
+		if signature == "" {
+			requestLogger(r.Context()).Warn("Missing webhook signature", zap.String("header", v.header), requestPathField(r))
+			http.Error(w, "Missing signature header", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				requestLogger(r.Context()).Warn("Request body too large", zap.Int64("limit", maxBytesErr.Limit))
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			requestLogger(r.Context()).Error("Failed to read request body", zap.Error(err))
+			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+			return
+		}
+		defer r.Body.Close()
+
+		// Restore body for next handler
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+		if err := v.Verify(signature, body); err != nil {
+			requestLogger(r.Context()).Warn("Invalid webhook signature", zap.String("scheme", v.scheme), requestPathField(r), zap.Error(err))
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WebhookRoute is an endpoint accepting signed user events
+type WebhookRoute struct {
+	Path     string
+	Verifier *SignatureVerifier
+}
+
+// BuildWebhookRoutes returns /userli and the additional routes below /webhooks/.
+// Routes are configured as name=scheme or name=scheme:Header and use the
+// webhook secret unless a route secret is set.
+func BuildWebhookRoutes(config *Config) ([]WebhookRoute, error) {
+	verifier, err := NewSignatureVerifier(config.WebhookSecret, config.WebhookSignatureScheme, config.WebhookSignatureHeader, config.WebhookSignatureTolerance)
+	if err != nil {
+		return nil, fmt.Errorf("route /userli: %w", err)
+	}
+	routes := []WebhookRoute{{Path: "/userli", Verifier: verifier}}
+
+	names := make([]string, 0, len(config.WebhookRoutes))
+	for name := range config.WebhookRoutes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for name := range config.WebhookRouteSecrets {
+		if _, ok := config.WebhookRoutes[name]; !ok {
+			return nil, fmt.Errorf("secret for unknown route %q", name)
+		}
+	}
+
+	for _, name := range names {
+		if !validRouteName(name) {
+			return nil, fmt.Errorf("invalid route name %q", name)
+		}
+
+		scheme, header, _ := strings.Cut(config.WebhookRoutes[name], ":")
+		secret := config.WebhookSecret
+		if routeSecret := config.WebhookRouteSecrets[name]; routeSecret != "" {
+			secret = routeSecret
+		}
+
+		verifier, err := NewSignatureVerifier(secret, strings.TrimSpace(scheme), strings.TrimSpace(header), config.WebhookSignatureTolerance)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", name, err)
+		}
+		routes = append(routes, WebhookRoute{Path: webhookRoutePrefix + name, Verifier: verifier})
+	}
+
+	return routes, nil
+}
+
+// validRouteName reports whether the name is usable as a single path segment
+func validRouteName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// userliRoutes returns the default /userli route verifying hex signatures with the secret
+func userliRoutes(secret string) []WebhookRoute {
+	verifier, err := NewSignatureVerifier(secret, SignatureSchemeHex, DefaultSignatureHeader, 0)
+	if err != nil {
+		panic(err)
+	}
+
+	return []WebhookRoute{{Path: "/userli", Verifier: verifier}}
+}
+
+// sign returns the HMAC SHA256 of the payload
+func sign(secret, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func TestSignatureVerifier_Verify(t *testing.T) {
+	body := `{"type":"user.deleted","data":{"email":"user@example.org"}}`
+	digest := sign("secret", body)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		scheme    string
+		signature string
+		valid     bool
+	}{
+		{"hex", SignatureSchemeHex, hex.EncodeToString(digest), true},
+		{"hex truncated", SignatureSchemeHex, hex.EncodeToString(digest)[:10], false},
+		{"hex wrong secret", SignatureSchemeHex, hex.EncodeToString(sign("other", body)), false},
+		{"hex with prefix", SignatureSchemeHex, "sha256=" + hex.EncodeToString(digest), false},
+		{"sha256 prefix", SignatureSchemeSHA256, "sha256=" + hex.EncodeToString(digest), true},
+		{"sha256 without prefix", SignatureSchemeSHA256, hex.EncodeToString(digest), false},
+		{"base64", SignatureSchemeBase64, base64.StdEncoding.EncodeToString(digest), true},
+		{"base64 without padding", SignatureSchemeBase64, base64.RawStdEncoding.EncodeToString(digest), true},
+		{"base64 URL-safe", SignatureSchemeBase64, base64.URLEncoding.EncodeToString(digest), true},
+		{"base64 wrong digest", SignatureSchemeBase64, base64.StdEncoding.EncodeToString(sign("other", body)), false},
+		{"timestamped", SignatureSchemeTimestamped, "t=" + now + ",v1=" + hex.EncodeToString(sign("secret", now+"."+body)), true},
+		{"timestamped rotated secret", SignatureSchemeTimestamped, "t=" + now + ",v1=" + hex.EncodeToString(sign("old", now+"."+body)) + ",v1=" + hex.EncodeToString(sign("secret", now+"."+body)), true},
+		{"timestamped over body only", SignatureSchemeTimestamped, "t=" + now + ",v1=" + hex.EncodeToString(digest), false},
+		{"timestamped replayed", SignatureSchemeTimestamped, "t=" + old + ",v1=" + hex.EncodeToString(sign("secret", old+"."+body)), false},
+		{"timestamped without timestamp", SignatureSchemeTimestamped, "v1=" + hex.EncodeToString(sign("secret", "."+body)), false},
+		{"timestamped without digest", SignatureSchemeTimestamped, "t=" + now, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, err := NewSignatureVerifier("secret", tt.scheme, "", 5*time.Minute)
+			require.NoError(t, err)
+
+			err = verifier.Verify(tt.signature, []byte(body))
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestSignatureVerifier_ToleranceDisabled(t *testing.T) {
+	verifier, err := NewSignatureVerifier("secret", SignatureSchemeTimestamped, "", 0)
+	require.NoError(t, err)
+
+	old := strconv.FormatInt(time.Now().Add(-24*time.Hour).Unix(), 10)
+	assert.NoError(t, verifier.Verify("t="+old+",v1="+hex.EncodeToString(sign("secret", old+".{}")), []byte("{}")))
+}
+
+func TestNewSignatureVerifier_Invalid(t *testing.T) {
+	_, err := NewSignatureVerifier("secret", "md5", "", 0)
+	assert.Error(t, err)
+
+	_, err = NewSignatureVerifier("", SignatureSchemeHex, "", 0)
+	assert.Error(t, err)
+}
+
+func TestBuildWebhookRoutes(t *testing.T) {
+	config := &Config{
+		WebhookSecret:             "secret",
+		WebhookSignatureScheme:    SignatureSchemeHex,
+		WebhookSignatureHeader:    DefaultSignatureHeader,
+		WebhookSignatureTolerance: 5 * time.Minute,
+		WebhookRoutes:             map[string]string{"crm": "sha256", "billing": "timestamped:Billing-Signature"},
+		WebhookRouteSecrets:       map[string]string{"billing": "billing-secret"},
+	}
+
+	routes, err := BuildWebhookRoutes(config)
+	require.NoError(t, err)
+	require.Len(t, routes, 3)
+
+	assert.Equal(t, "/userli", routes[0].Path)
+	assert.Equal(t, SignatureSchemeHex, routes[0].Verifier.scheme)
+	assert.Equal(t, "/webhooks/billing", routes[1].Path)
+	assert.Equal(t, SignatureSchemeTimestamped, routes[1].Verifier.scheme)
+	assert.Equal(t, "Billing-Signature", routes[1].Verifier.header)
+	assert.Equal(t, []byte("billing-secret"), routes[1].Verifier.secret)
+	assert.Equal(t, "/webhooks/crm", routes[2].Path)
+	assert.Equal(t, DefaultSignatureHeader, routes[2].Verifier.header)
+	assert.Equal(t, []byte("secret"), routes[2].Verifier.secret)
+
+	for _, invalid := range []*Config{
+		{WebhookSecret: "secret", WebhookSignatureScheme: "md5"},
+		{WebhookSecret: "secret", WebhookSignatureScheme: SignatureSchemeHex, WebhookRoutes: map[string]string{"crm": "md5"}},
+		{WebhookSecret: "secret", WebhookSignatureScheme: SignatureSchemeHex, WebhookRoutes: map[string]string{"../admin": "hex"}},
+		{WebhookSecret: "secret", WebhookSignatureScheme: SignatureSchemeHex, WebhookRouteSecrets: map[string]string{"crm": "secret"}},
+	} {
+		_, err := BuildWebhookRoutes(invalid)
+		assert.Error(t, err)
+	}
+}
+
+func TestWebhookRoutes(t *testing.T) {
+	logger = zap.NewNop()
+
+	routes, err := BuildWebhookRoutes(&Config{
+		WebhookSecret:          "secret",
+		WebhookSignatureScheme: SignatureSchemeBase64,
+		WebhookRoutes:          map[string]string{"crm": "sha256:X-Hub-Signature-256"},
+	})
+	require.NoError(t, err)
+
+	db, err := NewDatabase(t.TempDir()+"/mailboxes.csv", t.TempDir()+"/domain_holds.csv", NewEmailNormalizer(true))
+	require.NoError(t, err)
+
+	server := NewServer("", db, NewEmailNormalizer(true), routes, ServerOptions{})
+	server.RegisterRoutes()
+
+	body := func(email string) string { return `{"type":"user.deleted","data":{"email":"` + email + `"}}` }
+	send := func(path, header, signature, email string) int {
+		req := httptest.NewRequest("POST", path, bytes.NewBufferString(body(email)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(header, signature)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send("/userli", DefaultSignatureHeader, base64.StdEncoding.EncodeToString(sign("secret", body("a@example.org"))), "a@example.org"))
+	assert.Equal(t, http.StatusUnauthorized, send("/userli", DefaultSignatureHeader, hex.EncodeToString(sign("secret", body("b@example.org"))), "b@example.org"))
+	assert.Equal(t, http.StatusOK, send("/webhooks/crm", "X-Hub-Signature-256", "sha256="+hex.EncodeToString(sign("secret", body("c@example.org"))), "c@example.org"))
+	assert.Equal(t, http.StatusUnauthorized, send("/webhooks/crm", DefaultSignatureHeader, "sha256="+hex.EncodeToString(sign("secret", body("d@example.org"))), "d@example.org"))
+	assert.Equal(t, http.StatusNotFound, send("/webhooks/billing", DefaultSignatureHeader, "", "e@example.org"))
+
+	// Events of all routes end up in the same queue
+	mailboxes, err := db.GetMailboxes()
+	require.NoError(t, err)
+	require.Len(t, mailboxes, 2)
+	assert.Equal(t, "a@example.org", mailboxes[0].Email)
+	assert.Equal(t, "c@example.org", mailboxes[1].Email)
+}
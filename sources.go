@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Forwarding headers set by trusted proxies
+const (
+	ProxyHeaderXForwardedFor = "X-Forwarded-For"
+	ProxyHeaderForwarded     = "Forwarded"
+)
+
+// SourceFilter restricts webhook and admin requests to allowed client addresses.
+// Forwarding headers are only honored from trusted proxies.
+type SourceFilter struct {
+	allowed        []netip.Prefix
+	trustedProxies []netip.Prefix
+	proxyHeader    string
+}
+
+// NewSourceFilter creates a source filter from CIDRs or single addresses; an
+// empty allowlist allows all sources. Only the proxyHeader is read from trusted
+// proxies, as a proxy passes on the other header as sent by the client.
+func NewSourceFilter(allowed, trustedProxies []string, proxyHeader string) (*SourceFilter, error) {
+	allowedPrefixes, err := parsePrefixes(allowed)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyPrefixes, err := parsePrefixes(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	switch proxyHeader = http.CanonicalHeaderKey(proxyHeader); proxyHeader {
+	case ProxyHeaderXForwardedFor, ProxyHeaderForwarded:
+	default:
+		return nil, fmt.Errorf("unknown proxy header %q", proxyHeader)
+	}
+
+	return &SourceFilter{allowed: allowedPrefixes, trustedProxies: proxyPrefixes, proxyHeader: proxyHeader}, nil
+}
+
+// parsePrefixes parses CIDRs, single addresses are treated as /32 or /128
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if addr, err := netip.ParseAddr(value); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or CIDR %q", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// containsAddr reports whether one of the prefixes contains the address
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allowed reports whether the client address may call the protected endpoints
+func (f *SourceFilter) Allowed(addr netip.Addr) bool {
+	if f == nil || len(f.allowed) == 0 {
+		return true
+	}
+
+	return addr.IsValid() && containsAddr(f.allowed, addr)
+}
+
+// ClientIP returns the address of the client. Behind trusted proxies the
+// forwarding headers are walked from the nearest hop until the first address
+// that is not a trusted proxy.
+func (f *SourceFilter) ClientIP(r *http.Request) netip.Addr {
+	remote := remoteAddr(r)
+	if f == nil || !containsAddr(f.trustedProxies, remote) {
+		return remote
+	}
+
+	hops := forwardedFor(r.Header, f.proxyHeader)
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		client = hops[i]
+		if !client.IsValid() || !containsAddr(f.trustedProxies, client) {
+			break
+		}
+	}
+
+	return client.Unmap()
+}
+
+// remoteAddr returns the address of the peer. Connections through a Unix
+// socket come from a local proxy and are reported as 127.0.0.1.
+func remoteAddr(r *http.Request) netip.Addr {
+	if addrPort, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		return addrPort.Addr().Unmap()
+	}
+
+	if addr, err := netip.ParseAddr(r.RemoteAddr); err == nil {
+		return addr.Unmap()
+	}
+
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" || strings.HasPrefix(r.RemoteAddr, "/") {
+		return netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	}
+
+	return netip.Addr{}
+}
+
+// forwardedFor returns the client addresses from the Forwarded or X-Forwarded-For
+// header, in order from the original client to the nearest proxy. Unparsable
+// entries like "unknown" are returned as invalid addresses.
+func forwardedFor(header http.Header, name string) []netip.Addr {
+	var hops []netip.Addr
+
+	if name == ProxyHeaderForwarded {
+		for _, element := range strings.Split(strings.Join(header.Values(ProxyHeaderForwarded), ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, parseHop(strings.Trim(value, `"`)))
+				}
+			}
+		}
+
+		return hops
+	}
+
+	for _, value := range strings.Split(strings.Join(header.Values(ProxyHeaderXForwardedFor), ","), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			hops = append(hops, parseHop(value))
+		}
+	}
+
+	return hops
+}
+
+// parseHop parses an address with optional port and IPv6 brackets
+func parseHop(value string) netip.Addr {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+
+	addr, err := netip.ParseAddr(strings.Trim(value, "[]"))
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
+
+// SourceMiddleware rejects requests from clients outside the source allowlist
+func (s *Server) SourceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := s.sources.ClientIP(r)
+		if !s.sources.Allowed(client) {
+			requestLogger(r.Context()).Warn("Rejected request from disallowed source",
+				zap.Stringer("clientIP", client),
+				zap.String("remoteAddr", r.RemoteAddr),
+				requestPathField(r))
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
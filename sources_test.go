@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNewSourceFilter_Invalid(t *testing.T) {
+	_, err := NewSourceFilter([]string{"10.0.0.0/33"}, nil, ProxyHeaderXForwardedFor)
+	assert.Error(t, err)
+
+	_, err = NewSourceFilter(nil, []string{"proxy.example.org"}, ProxyHeaderXForwardedFor)
+	assert.Error(t, err)
+
+	_, err = NewSourceFilter(nil, nil, "X-Real-IP")
+	assert.Error(t, err)
+}
+
+func TestSourceFilter_Allowed(t *testing.T) {
+	filter, err := NewSourceFilter([]string{"192.0.2.0/24", " 2001:db8::1 ", "198.51.100.7"}, nil, ProxyHeaderXForwardedFor)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		addr    string
+		allowed bool
+	}{
+		{"inside CIDR", "192.0.2.10", true},
+		{"single IPv4 address", "198.51.100.7", true},
+		{"single IPv6 address", "2001:db8::1", true},
+		{"IPv4-mapped IPv6", "::ffff:192.0.2.10", true},
+		{"outside CIDR", "192.0.3.1", false},
+		{"neighbour of single address", "198.51.100.8", false},
+		{"other IPv6 address", "2001:db8::2", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, filter.Allowed(netip.MustParseAddr(tt.addr)))
+		})
+	}
+
+	assert.False(t, filter.Allowed(netip.Addr{}))
+
+	// Without an allowlist all sources are allowed
+	open, err := NewSourceFilter(nil, nil, ProxyHeaderXForwardedFor)
+	require.NoError(t, err)
+	assert.True(t, open.Allowed(netip.MustParseAddr("203.0.113.1")))
+
+	var disabled *SourceFilter
+	assert.True(t, disabled.Allowed(netip.MustParseAddr("203.0.113.1")))
+}
+
+func TestSourceFilter_ClientIP(t *testing.T) {
+	xForwardedFor, err := NewSourceFilter(nil, []string{"10.0.0.0/8", "127.0.0.1"}, ProxyHeaderXForwardedFor)
+	require.NoError(t, err)
+	forwarded, err := NewSourceFilter(nil, []string{"10.0.0.0/8", "127.0.0.1"}, "forwarded")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		filter     *SourceFilter
+		remoteAddr string
+		headers    map[string]string
+		client     string
+	}{
+		{"direct connection", xForwardedFor, "192.0.2.10:4711", nil, "192.0.2.10"},
+		{"headers from untrusted peer are ignored", xForwardedFor, "192.0.2.10:4711", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "192.0.2.10"},
+		{"trusted proxy without headers", xForwardedFor, "10.0.0.1:4711", nil, "10.0.0.1"},
+		{"X-Forwarded-For from trusted proxy", xForwardedFor, "10.0.0.1:4711", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		{"spoofed entries before the nearest untrusted hop", xForwardedFor, "10.0.0.1:4711", map[string]string{"X-Forwarded-For": "203.0.113.99, 198.51.100.7, 10.0.0.2"}, "198.51.100.7"},
+		{"only trusted hops", xForwardedFor, "10.0.0.1:4711", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"Forwarded header", forwarded, "10.0.0.1:4711", map[string]string{"Forwarded": `for=198.51.100.7;proto=https, for="[2001:db8::1]:4711"`}, "2001:db8::1"},
+		{"Forwarded sent by the client is ignored", xForwardedFor, "10.0.0.1:4711", map[string]string{"Forwarded": "for=198.51.100.7", "X-Forwarded-For": "203.0.113.99"}, "203.0.113.99"},
+		{"X-Forwarded-For sent by the client is ignored", forwarded, "10.0.0.1:4711", map[string]string{"Forwarded": "for=198.51.100.7", "X-Forwarded-For": "203.0.113.99"}, "198.51.100.7"},
+		{"IPv6 peer", xForwardedFor, "[2001:db8::5]:4711", nil, "2001:db8::5"},
+		{"Unix socket peer is a local proxy", xForwardedFor, "@", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/userli", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			assert.Equal(t, netip.MustParseAddr(tt.client), tt.filter.ClientIP(req))
+		})
+	}
+
+	// Obfuscated identifiers cannot be matched against the allowlist
+	req := httptest.NewRequest("POST", "/userli", nil)
+	req.RemoteAddr = "10.0.0.1:4711"
+	req.Header.Set("Forwarded", "for=unknown")
+	assert.False(t, forwarded.ClientIP(req).IsValid())
+}
+
+func TestSourceMiddleware(t *testing.T) {
+	logger = zap.NewNop()
+
+	sources, err := NewSourceFilter([]string{"192.0.2.0/24"}, []string{"10.0.0.1"}, ProxyHeaderXForwardedFor)
+	require.NoError(t, err)
+
+	server := NewServer("admin-token", nil, NewEmailNormalizer(true), userliRoutes("test-secret"), ServerOptions{Sources: sources})
+	server.RegisterRoutes()
+
+	request := func(method, path, remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, request("POST", "/userli", "203.0.113.1:4711", ""))
+	assert.Equal(t, http.StatusForbidden, request("GET", "/admin/holds", "203.0.113.1:4711", ""))
+	assert.Equal(t, http.StatusForbidden, request("POST", "/userli", "203.0.113.1:4711", "192.0.2.10"))
+	assert.Equal(t, http.StatusForbidden, request("POST", "/userli", "10.0.0.1:4711", "203.0.113.1"))
+
+	// A Forwarded header of the client does not override the X-Forwarded-For of the proxy
+	req := httptest.NewRequest("POST", "/userli", nil)
+	req.RemoteAddr = "10.0.0.1:4711"
+	req.Header.Set("Forwarded", "for=192.0.2.10")
+	req.Header.Set("X-Forwarded-For", "192.0.2.10, 203.0.113.1")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Allowed sources reach the authentication
+	assert.Equal(t, http.StatusUnauthorized, request("POST", "/userli", "192.0.2.10:4711", ""))
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/admin/holds", "10.0.0.1:4711", "192.0.2.10"))
+
+	// Health checks are not restricted
+	assert.Equal(t, http.StatusOK, request("GET", "/health", "203.0.113.1:4711", ""))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// sdNotify sends a state like READY=1 to systemd, it does nothing outside of a
+// Type=notify service
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	// Abstract sockets are passed with a leading @
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		logger.Warn("Failed to connect to systemd notify socket", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		logger.Warn("Failed to notify systemd", zap.String("state", state), zap.Error(err))
+	}
+}
+
+// sdWatchdogInterval returns the interval for watchdog pings, half of the timeout
+// configured with WatchdogSec=, or 0 if the watchdog is disabled
+func sdWatchdogInterval() time.Duration {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		logger.Warn("Invalid WATCHDOG_USEC, watchdog disabled", zap.String("value", usec))
+		return 0
+	}
+
+	return time.Duration(n) * time.Microsecond / 2
+}
+
+// RunWatchdog pings the systemd watchdog while the worker ticks regularly. Pings
+// stop while the worker is stalled, so systemd restarts the service.
+func (w *Worker) RunWatchdog(ctx context.Context, interval time.Duration) {
+	logger.Info("Starting systemd watchdog", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.CheckTick(); err != nil {
+				logger.Warn("Skipping watchdog ping", zap.Error(err))
+				continue
+			}
+			sdNotify("WATCHDOG=1")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
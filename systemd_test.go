@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type SystemdTestSuite struct {
+	suite.Suite
+	socket *net.UnixConn
+}
+
+func (s *SystemdTestSuite) SetupTest() {
+	logger = zap.NewNop()
+
+	dir, err := os.MkdirTemp("", "janitor")
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "notify.sock")
+	s.socket, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { _ = s.socket.Close() })
+
+	s.T().Setenv("NOTIFY_SOCKET", path)
+	s.T().Setenv("WATCHDOG_USEC", "")
+	s.T().Setenv("WATCHDOG_PID", "")
+}
+
+// receive returns the next message sent to the notify socket, empty if there is none
+func (s *SystemdTestSuite) receive(timeout time.Duration) string {
+	s.Require().NoError(s.socket.SetReadDeadline(time.Now().Add(timeout)))
+
+	buf := make([]byte, 1024)
+	n, err := s.socket.Read(buf)
+	if err != nil {
+		return ""
+	}
+
+	return string(buf[:n])
+}
+
+func (s *SystemdTestSuite) TestSdNotify() {
+	sdNotify("READY=1")
+	s.Equal("READY=1", s.receive(time.Second))
+
+	sdNotify("STOPPING=1")
+	s.Equal("STOPPING=1", s.receive(time.Second))
+}
+
+func (s *SystemdTestSuite) TestSdNotify_WithoutSocket() {
+	s.T().Setenv("NOTIFY_SOCKET", "")
+
+	sdNotify("READY=1")
+	s.Empty(s.receive(50 * time.Millisecond))
+}
+
+func (s *SystemdTestSuite) TestSdWatchdogInterval() {
+	s.Equal(time.Duration(0), sdWatchdogInterval())
+
+	s.T().Setenv("WATCHDOG_USEC", "30000000")
+	s.Equal(15*time.Second, sdWatchdogInterval())
+
+	s.T().Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	s.Equal(15*time.Second, sdWatchdogInterval())
+
+	// The watchdog belongs to another process
+	s.T().Setenv("WATCHDOG_PID", "1")
+	s.Equal(time.Duration(0), sdWatchdogInterval())
+
+	s.T().Setenv("WATCHDOG_PID", "")
+	s.T().Setenv("WATCHDOG_USEC", "soon")
+	s.Equal(time.Duration(0), sdWatchdogInterval())
+}
+
+func (s *SystemdTestSuite) TestRunWatchdog() {
+	worker := NewWorker(nil, nil, time.Minute, 0, NewEmailNormalizer(true), WorkerOptions{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		worker.RunWatchdog(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// No pings before the worker ticked
+	s.Empty(s.receive(50 * time.Millisecond))
+
+	worker.heartbeat.beginTick()
+	worker.heartbeat.endTick()
+	s.Equal("WATCHDOG=1", s.receive(time.Second))
+
+	// A stalled worker stops the pings
+	worker.heartbeat.beginTick()
+	worker.heartbeat.mu.Lock()
+	worker.heartbeat.status.Stalled = true
+	worker.heartbeat.mu.Unlock()
+
+	// Drain a ping sent before the stall was recorded
+	s.receive(20 * time.Millisecond)
+	s.Empty(s.receive(50 * time.Millisecond))
+}
+
+func TestSystemdTestSuite(t *testing.T) {
+	suite.Run(t, new(SystemdTestSuite))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// tlsReloadInterval limits how often the certificate files are checked for changes
+const tlsReloadInterval = 10 * time.Second
+
+// certReloader serves the certificate and client CA bundle from disk and
+// reloads them when the files change, e.g. after a certbot renewal
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+// NewTLSConfig creates a TLS configuration reloading the certificate on change.
+// With a client CA bundle, client certificates signed by it are verified.
+func NewTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.reloadIfChanged()
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return r.cert, nil
+		},
+	}
+
+	if caFile != "" {
+		// Connections without a client certificate are accepted for health checks,
+		// the webhook and admin routes require a verified certificate
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.reloadIfChanged()
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			clientConfig := config.Clone()
+			clientConfig.GetConfigForClient = nil
+			clientConfig.ClientCAs = r.clientCAs
+			return clientConfig, nil
+		}
+	}
+
+	return config, nil
+}
+
+// files returns the files watched for changes
+func (r *certReloader) files() []string {
+	if r.caFile == "" {
+		return []string{r.certFile, r.keyFile}
+	}
+
+	return []string{r.certFile, r.keyFile, r.caFile}
+}
+
+// load reads the certificate, key and client CA bundle
+func (r *certReloader) load() error {
+	var modTimes []time.Time
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return errors.New("no certificates found in client CA bundle")
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	return nil
+}
+
+// reloadIfChanged reloads the files if one of them was modified, keeping the
+// current certificate if the new files cannot be loaded
+func (r *certReloader) reloadIfChanged() {
+	r.mu.Lock()
+	if time.Since(r.lastCheck) < tlsReloadInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastCheck = time.Now()
+
+	changed := false
+	for i, file := range r.files() {
+		if info, err := os.Stat(file); err == nil && !info.ModTime().Equal(r.modTimes[i]) {
+			changed = true
+		}
+	}
+	r.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if err := r.load(); err != nil {
+		logger.Error("Failed to reload TLS certificate, keeping the current one", zap.Error(err))
+		return
+	}
+
+	logger.Info("TLS certificate reloaded", zap.String("certFile", r.certFile))
+}
+
+// ClientCertMiddleware rejects requests without a verified client certificate
+func (s *Server) ClientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.requireClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			requestLogger(r.Context()).Warn("Missing client certificate", requestPathField(r))
+			http.Error(w, "Client certificate required", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
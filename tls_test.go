@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+// testCA issues certificates for the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a certificate and key for commonName signed by the CA
+func (ca *testCA) issue(t *testing.T, dir, commonName string, usage x509.ExtKeyUsage) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{commonName},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, commonName+".crt")
+	keyFile := filepath.Join(dir, commonName+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+type TLSTestSuite struct {
+	suite.Suite
+	dir      string
+	ca       *testCA
+	caFile   string
+	certFile string
+	keyFile  string
+}
+
+func (s *TLSTestSuite) SetupTest() {
+	logger = zap.NewNop()
+
+	s.dir = s.T().TempDir()
+	s.ca = newTestCA(s.T())
+	s.caFile = filepath.Join(s.dir, "ca.pem")
+	s.Require().NoError(os.WriteFile(s.caFile, s.ca.pem, 0600))
+	s.certFile, s.keyFile = s.ca.issue(s.T(), s.dir, "localhost", x509.ExtKeyUsageServerAuth)
+}
+
+// serve starts the server on a local TLS listener and returns its URL
+func (s *TLSTestSuite) serve(server *Server, tlsConfig *tls.Config) string {
+	server.requireClientCert = tlsConfig.ClientAuth != tls.NoClientCert
+	server.RegisterRoutes()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	s.Require().NoError(err)
+
+	httpServer := &http.Server{Handler: server.router, ErrorLog: zap.NewStdLog(logger)}
+	go func() { _ = httpServer.Serve(listener) }()
+	s.T().Cleanup(func() { _ = httpServer.Close() })
+
+	return "https://" + listener.Addr().String()
+}
+
+// client returns a client trusting the test CA, presenting the certificate if given
+func (s *TLSTestSuite) client(certFile, keyFile string) *http.Client {
+	transport, err := clientTransport("", s.caFile, certFile, keyFile)
+	s.Require().NoError(err)
+
+	return &http.Client{Transport: transport, Timeout: 5 * time.Second}
+}
+
+func (s *TLSTestSuite) TestNewTLSConfig_InvalidFiles() {
+	_, err := NewTLSConfig(filepath.Join(s.dir, "missing.crt"), s.keyFile, "")
+	s.Error(err)
+
+	_, err = NewTLSConfig(s.certFile, s.caFile, "")
+	s.Error(err)
+
+	_, err = NewTLSConfig(s.certFile, s.keyFile, s.keyFile)
+	s.Error(err)
+}
+
+func (s *TLSTestSuite) TestServeTLS() {
+	tlsConfig, err := NewTLSConfig(s.certFile, s.keyFile, "")
+	s.Require().NoError(err)
+	s.Equal(tls.NoClientCert, tlsConfig.ClientAuth)
+
+	url := s.serve(NewServer("admin-token", nil, NewEmailNormalizer(true), userliRoutes("test-secret"), ServerOptions{}), tlsConfig)
+
+	resp, err := s.client("", "").Get(url + "/health")
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+
+	// Without client CA the webhook only requires the signature
+	resp, err = s.client("", "").Post(url+"/userli", "application/json", nil)
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+}
+
+func (s *TLSTestSuite) TestServeMutualTLS() {
+	tlsConfig, err := NewTLSConfig(s.certFile, s.keyFile, s.caFile)
+	s.Require().NoError(err)
+
+	url := s.serve(NewServer("admin-token", nil, NewEmailNormalizer(true), userliRoutes("test-secret"), ServerOptions{}), tlsConfig)
+	clientCert, clientKey := s.ca.issue(s.T(), s.dir, "userli.example.org", x509.ExtKeyUsageClientAuth)
+
+	// Health checks work without a client certificate
+	resp, err := s.client("", "").Get(url + "/health")
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+
+	resp, err = s.client("", "").Post(url+"/userli", "application/json", nil)
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusForbidden, resp.StatusCode)
+
+	req, err := http.NewRequest("GET", url+"/admin/holds", nil)
+	s.Require().NoError(err)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	resp, err = s.client("", "").Do(req)
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusForbidden, resp.StatusCode)
+
+	// With a verified certificate the request reaches the signature check
+	resp, err = s.client(clientCert, clientKey).Post(url+"/userli", "application/json", nil)
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	// Certificates from another CA are rejected during the handshake
+	otherCert, otherKey := newTestCA(s.T()).issue(s.T(), s.T().TempDir(), "intruder.example.org", x509.ExtKeyUsageClientAuth)
+	_, err = s.client(otherCert, otherKey).Post(url+"/userli", "application/json", nil)
+	s.Error(err)
+}
+
+func (s *TLSTestSuite) TestCertReloader_ReloadsOnChange() {
+	r := &certReloader{certFile: s.certFile, keyFile: s.keyFile}
+	s.Require().NoError(r.load())
+	original := r.cert
+
+	// Unchanged files are not reloaded
+	r.reloadIfChanged()
+	s.Same(original, r.cert)
+
+	renewedCert, renewedKey := s.ca.issue(s.T(), s.T().TempDir(), "localhost", x509.ExtKeyUsageServerAuth)
+	s.replace(renewedCert, s.certFile)
+	s.replace(renewedKey, s.keyFile)
+
+	// Changes are only noticed once per interval
+	r.reloadIfChanged()
+	s.Same(original, r.cert)
+
+	r.lastCheck = time.Time{}
+	r.reloadIfChanged()
+	s.NotSame(original, r.cert)
+	s.NotEqual(original.Certificate[0], r.cert.Certificate[0])
+}
+
+func (s *TLSTestSuite) TestCertReloader_KeepsCertificateOnError() {
+	r := &certReloader{certFile: s.certFile, keyFile: s.keyFile}
+	s.Require().NoError(r.load())
+	original := r.cert
+
+	// A renewal writing the certificate before the key leaves a mismatched pair
+	renewedCert, _ := s.ca.issue(s.T(), s.T().TempDir(), "localhost", x509.ExtKeyUsageServerAuth)
+	s.replace(renewedCert, s.certFile)
+
+	r.lastCheck = time.Time{}
+	r.reloadIfChanged()
+	s.Same(original, r.cert)
+}
+
+// replace overwrites dst with src and moves its modification time forward
+func (s *TLSTestSuite) replace(src, dst string) {
+	data, err := os.ReadFile(src)
+	s.Require().NoError(err)
+	s.Require().NoError(os.WriteFile(dst, data, 0600))
+
+	modTime := time.Now().Add(time.Minute)
+	s.Require().NoError(os.Chtimes(dst, modTime, modTime))
+}
+
+func TestTLSTestSuite(t *testing.T) {
+	suite.Run(t, new(TLSTestSuite))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+)
+
+// tracerName is the instrumentation scope of all spans
+const tracerName = "github.com/systemli/userli-mailbox-janitor"
+
+// otlpTracesPath is appended to OTLP endpoints without a path
+const otlpTracesPath = "/v1/traces"
+
+// tracer creates the spans, it does not record anything until tracing is enabled
+var tracer trace.Tracer = noop.NewTracerProvider().Tracer(tracerName)
+
+// tracePropagator reads and writes W3C traceparent headers
+var tracePropagator = propagation.TraceContext{}
+
+// InitTracing exports spans to the OTLP HTTP endpoint, e.g. http://otel-collector:4318.
+// The returned function flushes pending spans on shutdown.
+func InitTracing(endpoint string) (func(context.Context) error, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", endpoint)
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpTracesPath
+	}
+
+	// Headers like authentication tokens are taken from OTEL_EXPORTER_OTLP_HEADERS
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(u.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", logIdentifier))),
+	)
+	tracer = provider.Tracer(tracerName)
+
+	return provider.Shutdown, nil
+}
+
+// TracingMiddleware starts a server span for the request, continuing the trace
+// of the traceparent header sent by userli. It must follow the signature check.
+func (s *Server) TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("request.id", RequestID(ctx)),
+			))
+		defer span.End()
+
+		// Log lines of the request can be found from the trace
+		if span.IsRecording() {
+			ctx = context.WithValue(ctx, requestLoggerKey, requestLogger(ctx).With(zap.Stringer("traceID", span.SpanContext().TraceID())))
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// traceParent returns the traceparent of the recording span in the context, empty otherwise
+func traceParent(ctx context.Context) string {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return ""
+	}
+
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// startPurgeSpan starts a new trace for the purge of a mailbox, linked to the
+// webhook request that queued it
+func startPurgeSpan(mailbox Mailbox) (context.Context, trace.Span) {
+	options := []trace.SpanStartOption{
+		trace.WithNewRoot(),
+		trace.WithAttributes(emailAttribute(mailbox.Email)),
+	}
+
+	if mailbox.TraceParent != "" {
+		queued := tracePropagator.Extract(context.Background(), propagation.MapCarrier{"traceparent": mailbox.TraceParent})
+		if link := trace.SpanContextFromContext(queued); link.IsValid() {
+			options = append(options, trace.WithLinks(trace.Link{SpanContext: link}))
+		}
+	}
+
+	return tracer.Start(context.Background(), "worker purge", options...)
+}
+
+// startDoveadmSpan starts a client span for a doveadm command
+func startDoveadmSpan(ctx context.Context, command, email string, attributes ...attribute.KeyValue) trace.Span {
+	attributes = append(attributes, attribute.String("doveadm.command", command), emailAttribute(email))
+	_, span := tracer.Start(ctx, "doveadm "+command, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+	return span
+}
+
+// endSpan ends the span, marking it as failed if *err is set
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		recordSpanError(span, *err)
+	}
+	span.End()
+}
+
+// recordSpanError marks the span as failed
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// emailAttribute returns the email address as span attribute, pseudonymized like in the logs
+func emailAttribute(email string) attribute.KeyValue {
+	return attribute.String("mailbox.email", logEmails.pseudonymize(email))
+}
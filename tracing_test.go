@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+)
+
+// userliTraceParent is the traceparent sent by userli in the tests
+const userliTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+type TracingTestSuite struct {
+	suite.Suite
+	exporter *tracetest.InMemoryExporter
+	db       *Database
+	server   *Server
+}
+
+func (s *TracingTestSuite) SetupTest() {
+	logger = zap.NewNop()
+
+	s.exporter = tracetest.NewInMemoryExporter()
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSyncer(s.exporter)).Tracer(tracerName)
+
+	var err error
+	s.db, err = NewDatabase(filepath.Join(s.T().TempDir(), "mailboxes.csv"), filepath.Join(s.T().TempDir(), "domain_holds.csv"), NewEmailNormalizer(true))
+	s.Require().NoError(err)
+
+	s.server = NewServer("", s.db, NewEmailNormalizer(true), userliRoutes("secret"), ServerOptions{})
+	s.server.RegisterRoutes()
+}
+
+func (s *TracingTestSuite) TearDownTest() {
+	tracer = noop.NewTracerProvider().Tracer(tracerName)
+}
+
+func (s *TracingTestSuite) sendEvent(traceParent string) *httptest.ResponseRecorder {
+	body := `{"type":"user.deleted","data":{"email":"user@example.org"}}`
+	req := httptest.NewRequest("POST", "/userli", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DefaultSignatureHeader, hex.EncodeToString(sign("secret", body)))
+	if traceParent != "" {
+		req.Header.Set("traceparent", traceParent)
+	}
+
+	w := httptest.NewRecorder()
+	s.server.router.ServeHTTP(w, req)
+	return w
+}
+
+// span returns the exported span with the name
+func (s *TracingTestSuite) span(name string) tracetest.SpanStub {
+	for _, span := range s.exporter.GetSpans() {
+		if span.Name == name {
+			return span
+		}
+	}
+
+	s.FailNow("span not found", name)
+	return tracetest.SpanStub{}
+}
+
+func (s *TracingTestSuite) TestWebhookAndPurge() {
+	s.Require().Equal(http.StatusOK, s.sendEvent(userliTraceParent).Code)
+
+	// The webhook continues the trace of userli
+	request := s.span("POST /userli")
+	s.Equal("4bf92f3577b34da6a3ce929d0e0e4736", request.SpanContext.TraceID().String())
+	s.Equal("00f067aa0ba902b7", request.Parent.SpanID().String())
+	s.Equal(trace.SpanKindServer, request.SpanKind)
+
+	write := s.span("database add mailbox")
+	s.Equal(request.SpanContext.SpanID(), write.Parent.SpanID())
+
+	// The queued mailbox remembers the request for the purge
+	mailboxes, err := s.db.GetMailboxes()
+	s.Require().NoError(err)
+	s.Require().Len(mailboxes, 1)
+	s.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-"+request.SpanContext.SpanID().String()+"-01", mailboxes[0].TraceParent)
+
+	doveadm := filepath.Join(s.T().TempDir(), "doveadm")
+	s.Require().NoError(os.WriteFile(doveadm, []byte("#!/bin/sh\nexit 0\n"), 0o700))
+	worker := NewWorker(s.db, NewPurgers(NewDoveadmPurger(NewDoveadmExec(doveadm, false), VerifyModeNone), nil), time.Minute, 0, NewEmailNormalizer(true), WorkerOptions{})
+	worker.processDueMailboxes()
+
+	// The purge starts a new trace linked to the webhook request
+	purge := s.span("worker purge")
+	s.False(purge.Parent.IsValid())
+	s.NotEqual(request.SpanContext.TraceID(), purge.SpanContext.TraceID())
+	s.Require().Len(purge.Links, 1)
+	s.Equal(request.SpanContext.SpanID(), purge.Links[0].SpanContext.SpanID())
+	s.Equal(request.SpanContext.TraceID(), purge.Links[0].SpanContext.TraceID())
+
+	command := s.span("doveadm purge")
+	s.Equal(purge.SpanContext.SpanID(), command.Parent.SpanID())
+	s.Equal(trace.SpanKindClient, command.SpanKind)
+}
+
+func (s *TracingTestSuite) TestPurgeFailure() {
+	s.Require().Equal(http.StatusOK, s.sendEvent("").Code)
+
+	// Without a traceparent the webhook starts a new trace
+	request := s.span("POST /userli")
+	s.False(request.Parent.IsValid())
+
+	worker := NewWorker(s.db, NewPurgers(NewDoveadmPurger(NewDoveadmExec("/nonexistent/doveadm", false), VerifyModeNone), nil), time.Minute, 0, NewEmailNormalizer(true), WorkerOptions{})
+	worker.processDueMailboxes()
+
+	s.Equal("Error", s.span("doveadm purge").Status.Code.String())
+	s.Equal("Error", s.span("worker purge").Status.Code.String())
+}
+
+func (s *TracingTestSuite) TestUnauthenticatedRequest() {
+	body := `{"type":"user.deleted","data":{"email":"user@example.org"}}`
+	req := httptest.NewRequest("POST", "/userli", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DefaultSignatureHeader, hex.EncodeToString(sign("wrong", body)))
+	req.Header.Set("traceparent", userliTraceParent)
+	w := httptest.NewRecorder()
+	s.server.router.ServeHTTP(w, req)
+	s.Require().Equal(http.StatusUnauthorized, w.Code)
+
+	// Requests with an invalid signature are not traced
+	s.Empty(s.exporter.GetSpans())
+}
+
+func (s *TracingTestSuite) TestDisabled() {
+	tracer = noop.NewTracerProvider().Tracer(tracerName)
+
+	s.Require().Equal(http.StatusOK, s.sendEvent(userliTraceParent).Code)
+	s.Empty(s.exporter.GetSpans())
+
+	mailboxes, err := s.db.GetMailboxes()
+	s.Require().NoError(err)
+	s.Require().Len(mailboxes, 1)
+	s.Empty(mailboxes[0].TraceParent)
+}
+
+func (s *TracingTestSuite) TestInitTracing() {
+	defer func() { tracer = noop.NewTracerProvider().Tracer(tracerName) }()
+
+	for _, endpoint := range []string{"otel-collector:4318", "grpc://otel-collector:4317", "http://"} {
+		_, err := InitTracing(endpoint)
+		s.Error(err, endpoint)
+	}
+
+	shutdown, err := InitTracing("http://127.0.0.1:1")
+	s.Require().NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.NoError(shutdown(ctx))
+}
+
+func TestTracingTestSuite(t *testing.T) {
+	suite.Run(t, new(TracingTestSuite))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Verification modes for purged mailboxes
+const (
+	VerifyModeNone          = ""
+	VerifyModeMailboxStatus = "mailbox-status"
+	VerifyModeHome          = "home"
+)
+
+// parseMailboxStatus parses the flow output of doveadm mailbox status into
+// message counts of all non-empty mailboxes, e.g. "INBOX messages=3"
+func parseMailboxStatus(output string) (map[string]int, error) {
+	counts := make(map[string]int)
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		i := strings.LastIndex(line, "messages=")
+		if i < 0 {
+			return nil, fmt.Errorf("unexpected mailbox status line %q", line)
+		}
+
+		count, err := strconv.Atoi(line[i+len("messages="):])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected mailbox status line %q: %w", line, err)
+		}
+
+		if count > 0 {
+			counts[strings.TrimSpace(line[:i])] = count
+		}
+	}
+
+	return counts, nil
+}
+
+// formatResidual formats message counts as "INBOX=3;Sent=1"
+func formatResidual(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"="+strconv.Itoa(counts[name]))
+	}
+
+	return strings.Join(parts, ";")
+}
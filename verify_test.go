@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMailboxStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    map[string]int
+		wantErr bool
+	}{
+		{"empty output", "", map[string]int{}, false},
+		{"all empty", "INBOX messages=0\nSent messages=0\n", map[string]int{}, false},
+		{"residual messages", "INBOX messages=3\nSent messages=0\nSent Items messages=1\n", map[string]int{"INBOX": 3, "Sent Items": 1}, false},
+		{"unexpected output", "Error: User doesn't exist\n", nil, true},
+		{"invalid count", "INBOX messages=abc\n", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMailboxStatus(tt.output)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFormatResidual(t *testing.T) {
+	assert.Equal(t, "", formatResidual(map[string]int{}))
+	assert.Equal(t, "INBOX=3;Sent=1", formatResidual(map[string]int{"Sent": 1, "INBOX": 3}))
+}
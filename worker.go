@@ -4,10 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os/exec"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -17,20 +18,46 @@ var ErrInvalidEmail = errors.New("invalid email address")
 // Worker processes mailbox purging tasks periodically
 type Worker struct {
 	db             *Database
+	purgers        *Purgers
 	tickInterval   time.Duration
 	retentionHours int
-	doveadmPath    string
-	useSudo        bool
+	normalizer     *EmailNormalizer
+	protected      *ProtectedList
+	archiver       *Archiver
+	hooks          *Hooks
+	notifier       *Notifier
+	alerter        *Alerter
+	heartbeat      heartbeat
+	trigger        chan struct{}
+}
+
+// WorkerOptions holds the optional collaborators of a Worker, nil fields are disabled
+type WorkerOptions struct {
+	// TickWarnDuration logs ticks running longer as stalled, disabled if zero
+	TickWarnDuration time.Duration
+
+	Protected *ProtectedList
+	Archiver  *Archiver
+	Hooks     *Hooks
+	Notifier  *Notifier
+	Alerter   *Alerter
 }
 
 // NewWorker creates a new worker instance
-func NewWorker(db *Database, tickInterval time.Duration, retentionHours int, doveadmPath string, useSudo bool) *Worker {
+func NewWorker(db *Database, purgers *Purgers, tickInterval time.Duration, retentionHours int, normalizer *EmailNormalizer, opts WorkerOptions) *Worker {
 	return &Worker{
 		db:             db,
+		purgers:        purgers,
 		tickInterval:   tickInterval,
 		retentionHours: retentionHours,
-		doveadmPath:    doveadmPath,
-		useSudo:        useSudo,
+		normalizer:     normalizer,
+		protected:      opts.Protected,
+		archiver:       opts.Archiver,
+		hooks:          opts.Hooks,
+		notifier:       opts.Notifier,
+		alerter:        opts.Alerter,
+		heartbeat:      heartbeat{warnDuration: opts.TickWarnDuration},
+		trigger:        make(chan struct{}, 1),
 	}
 }
 
@@ -65,6 +92,7 @@ func validateEmail(email string) error {
 func (w *Worker) Start(ctx context.Context) {
 	logger.Info("Starting worker",
 		zap.Duration("tickInterval", w.tickInterval),
+		zap.Duration("tickWarnDuration", w.heartbeat.warnDuration),
 		zap.Int("retentionHours", w.retentionHours))
 
 	ticker := time.NewTicker(w.tickInterval)
@@ -77,6 +105,10 @@ func (w *Worker) Start(ctx context.Context) {
 		select {
 		case <-ticker.C:
 			w.processDueMailboxes()
+		case <-w.trigger:
+			logger.Info("Running worker on demand")
+			w.processDueMailboxes()
+			ticker.Reset(w.tickInterval)
 		case <-ctx.Done():
 			logger.Info("Worker stopped")
 			return
@@ -84,8 +116,33 @@ func (w *Worker) Start(ctx context.Context) {
 	}
 }
 
+// Trigger requests an immediate run. Requests while a run is pending are
+// coalesced into it, a request during a run starts another run afterwards.
+// It reports whether a new run was queued.
+func (w *Worker) Trigger(source string) bool {
+	select {
+	case w.trigger <- struct{}{}:
+		logger.Info("Worker run requested", zap.String("source", source))
+		return true
+	default:
+		logger.Info("Worker run already pending", zap.String("source", source))
+		return false
+	}
+}
+
 // processDueMailboxes processes all mailboxes that are due for purging
 func (w *Worker) processDueMailboxes() {
+	w.heartbeat.beginTick()
+	defer w.heartbeat.endTick()
+	defer w.alerter.Check()
+
+	w.archiver.Cleanup()
+	w.purgers.Cleanup()
+
+	if err := w.db.ReleaseExpiredHolds(); err != nil {
+		logger.Error("Failed to release expired holds", zap.Error(err))
+	}
+
 	mailboxes, err := w.db.GetDueMailboxes(w.retentionHours)
 	if err != nil {
 		logger.Error("Failed to get due mailboxes", zap.Error(err))
@@ -106,54 +163,111 @@ func (w *Worker) processDueMailboxes() {
 
 // processSingleMailbox purges a single mailbox
 func (w *Worker) processSingleMailbox(mailbox Mailbox) {
+	w.heartbeat.setCurrent(mailbox.Email)
+	defer w.heartbeat.setCurrent("")
+
+	ctx, span := startPurgeSpan(mailbox)
+	defer span.End()
+
+	// Entries may end up in the CSV in a non-canonical form through manual editing
+	email, err := w.normalizer.Normalize(mailbox.Email)
+	if err != nil {
+		logger.Error("Invalid email address in database",
+			emailField(mailbox.Email),
+			zap.Error(err))
+		recordSpanError(span, err)
+		return
+	}
+
+	// Entries for protected mailboxes may end up in the CSV through manual editing
+	if pattern, ok := w.protected.Match(email); ok {
+		logProtectedBlocked(logger, email, pattern, "worker")
+		span.SetStatus(codes.Error, "protected mailbox")
+		return
+	}
+
+	purger := w.purgers.For(email)
+
 	logger.Info("Purging mailbox",
-		zap.String("email", mailbox.Email),
+		emailField(email),
+		zap.String("backend", purger.Describe()),
 		zap.Time("created_at", mailbox.CreatedAt))
 
-	if err := w.purgeMailbox(mailbox.Email); err != nil {
-		logger.Error("Failed to purge mailbox",
-			zap.String("email", mailbox.Email),
+	// Everything verification needs must be looked up while the user still exists
+	prepared, err := purger.Prepare(email)
+	if err != nil {
+		logger.Error("Failed to prepare mailbox purge",
+			emailField(email),
 			zap.Error(err))
+		w.recordFailure(span, email, "prepare failed: "+err.Error())
 		return
 	}
 
-	if err := w.db.RemoveMailbox(mailbox.Email); err != nil {
-		logger.Error("Failed to remove mailbox from database",
-			zap.String("email", mailbox.Email),
-			zap.Error(err))
+	// Some domains require an encrypted export before the data is gone
+	if w.archiver.Enabled(email) {
+		if _, err := w.archiver.Export(email); err != nil {
+			logger.Error("Failed to export mailbox archive, skipping purge",
+				emailField(email),
+				zap.Error(err))
+			w.recordFailure(span, email, "archive export failed: "+err.Error())
+			return
+		}
+	}
+
+	if !w.hooks.BeforePurge(email, purger.Describe()) {
+		w.recordFailure(span, email, "pre-purge hook failed")
 		return
 	}
 
-	logger.Info("Mailbox purged successfully", zap.String("email", mailbox.Email))
-}
+	if err := purger.Purge(ctx, email); err != nil {
+		logger.Error("Failed to purge mailbox",
+			emailField(email),
+			zap.Error(err))
+		w.notifier.Notify(EventTypeMailboxPurgeFailed, MailboxEventData{Email: email, Backend: purger.Describe(), Error: err.Error()})
+		w.recordFailure(span, email, err.Error())
+		return
+	}
 
-// purgeMailbox executes the doveadm purge command for a mailbox
-func (w *Worker) purgeMailbox(email string) error {
-	// Validate email to prevent wildcard attacks
-	if err := validateEmail(email); err != nil {
-		return fmt.Errorf("email validation failed: %w", err)
+	residual, err := purger.Verify(email, prepared)
+	if err != nil {
+		logger.Error("Failed to verify mailbox purge",
+			emailField(email),
+			zap.Error(err))
+		w.recordFailure(span, email, "verification failed: "+err.Error())
+		return
 	}
 
-	var cmd *exec.Cmd
+	if residual != "" {
+		logger.Warn("Mailbox purge verification failed",
+			emailField(email),
+			zap.String("residual", residual))
 
-	if w.useSudo {
-		cmd = exec.Command("sudo", w.doveadmPath, "purge", "-u", email)
-	} else {
-		cmd = exec.Command(w.doveadmPath, "purge", "-u", email)
+		if err := w.db.MarkVerificationFailed(mailbox.Email, residual); err != nil {
+			logger.Error("Failed to mark mailbox verification failed",
+				emailField(email),
+				zap.Error(err))
+		}
+		w.notifier.Notify(EventTypeMailboxPurgeFailed, MailboxEventData{Email: email, Backend: purger.Describe(), Error: "verification failed: " + residual})
+		w.recordFailure(span, email, "verification failed: "+residual)
+		return
 	}
 
-	logger.Debug("Executing command",
-		zap.String("command", cmd.String()),
-		zap.String("email", email))
+	w.hooks.AfterPurge(email, purger.Describe())
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("doveadm purge failed: %w, output: %s", err, string(output))
+	if err := w.db.RemoveMailbox(mailbox.Email); err != nil {
+		logger.Error("Failed to remove mailbox from database",
+			emailField(email),
+			zap.Error(err))
+		return
 	}
 
-	logger.Debug("Command executed successfully",
-		zap.String("output", string(output)),
-		zap.String("email", email))
+	logger.Info("Mailbox purged successfully", emailField(email))
+	w.alerter.RecordSuccess(email)
+	w.notifier.Notify(EventTypeMailboxPurged, MailboxEventData{Email: email, Backend: purger.Describe()})
+}
 
-	return nil
+// recordFailure reports a failed purge to the alerter and marks the purge span as failed
+func (w *Worker) recordFailure(span trace.Span, email, reason string) {
+	span.SetStatus(codes.Error, reason)
+	w.alerter.RecordFailure(email, reason)
 }
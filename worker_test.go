@@ -2,20 +2,54 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"filippo.io/age"
 	"github.com/stretchr/testify/suite"
 	"go.uber.org/zap"
 )
 
+// fakePurger is a purge backend recording purged mailboxes
+type fakePurger struct {
+	purged     []string
+	prepareErr error
+	purgeErr   error
+	residual   string
+}
+
+func (p *fakePurger) Purge(ctx context.Context, email string) error {
+	if p.purgeErr != nil {
+		return p.purgeErr
+	}
+
+	p.purged = append(p.purged, email)
+	return nil
+}
+
+func (p *fakePurger) Prepare(email string) (string, error) {
+	return "", p.prepareErr
+}
+
+func (p *fakePurger) Verify(email, prepared string) (string, error) {
+	return p.residual, nil
+}
+
+func (p *fakePurger) Describe() string {
+	return "fake"
+}
+
 type WorkerTestSuite struct {
 	suite.Suite
-	db       *Database
-	worker   *Worker
-	tempFile string
+	db        *Database
+	worker    *Worker
+	purger    *fakePurger
+	tempFile  string
+	holdsFile string
 }
 
 func (s *WorkerTestSuite) SetupTest() {
@@ -24,19 +58,25 @@ func (s *WorkerTestSuite) SetupTest() {
 	// Use unique temporary file for each test
 	tempDir := os.TempDir()
 	s.tempFile = filepath.Join(tempDir, "test_worker_mailboxes.csv")
+	s.holdsFile = filepath.Join(tempDir, "test_worker_domain_holds.csv")
 	os.Remove(s.tempFile) // Ensure clean state
+	os.Remove(s.holdsFile)
 
 	var err error
-	s.db, err = NewDatabase(s.tempFile)
+	s.db, err = NewDatabase(s.tempFile, s.holdsFile, NewEmailNormalizer(true))
+	s.Require().NoError(err)
+
+	protected, err := NewProtectedList([]string{"postmaster@"})
 	s.Require().NoError(err)
 
-	// Use mock doveadm command for testing (just use 'echo' which exists on all systems)
-	s.worker = NewWorker(s.db, 100*time.Millisecond, 0, "/bin/echo", false)
+	s.purger = &fakePurger{}
+	s.worker = NewWorker(s.db, NewPurgers(s.purger, nil), 100*time.Millisecond, 0, NewEmailNormalizer(true), WorkerOptions{Protected: protected})
 }
 
 func (s *WorkerTestSuite) TearDownTest() {
 	s.db.Close()
 	os.Remove(s.tempFile)
+	os.Remove(s.holdsFile)
 }
 
 func (s *WorkerTestSuite) TestProcessDueMailboxes_Empty() {
@@ -46,7 +86,7 @@ func (s *WorkerTestSuite) TestProcessDueMailboxes_Empty() {
 
 func (s *WorkerTestSuite) TestProcessDueMailboxes_Success() {
 	// Add a mailbox
-	err := s.db.AddMailbox("test@example.com")
+	err := s.db.AddMailbox(context.Background(), "test@example.com")
 	s.NoError(err)
 
 	// Process mailboxes
@@ -56,14 +96,35 @@ func (s *WorkerTestSuite) TestProcessDueMailboxes_Success() {
 	mailboxes, err := s.db.GetDueMailboxes(0)
 	s.NoError(err)
 	s.Empty(mailboxes)
+	s.Equal([]string{"test@example.com"}, s.purger.purged)
+}
+
+func (s *WorkerTestSuite) TestProcessDueMailboxes_Notify() {
+	var err error
+	s.worker.notifier, err = NewNotifier([]string{"http://127.0.0.1:1/events"}, "test-secret", nil, filepath.Join(s.T().TempDir(), "outbox.csv"), 3, time.Second)
+	s.Require().NoError(err)
+
+	s.NoError(s.db.AddMailbox(context.Background(), "test@example.com"))
+
+	s.worker.processDueMailboxes()
+
+	outbox, err := s.worker.notifier.readOutbox()
+	s.Require().NoError(err)
+	s.Require().Len(outbox, 1)
+
+	var event MailboxEvent
+	s.NoError(json.Unmarshal([]byte(outbox[0].Body), &event))
+	s.Equal(EventTypeMailboxPurged, event.Type)
+	s.Equal("test@example.com", event.Data.Email)
+	s.Equal("fake", event.Data.Backend)
 }
 
 func (s *WorkerTestSuite) TestProcessDueMailboxes_CommandFails() {
-	// Use invalid command that will fail
-	s.worker.doveadmPath = "/nonexistent/command"
+	// Purge backend fails
+	s.purger.purgeErr = errors.New("doveadm purge failed")
 
 	// Add a mailbox
-	err := s.db.AddMailbox("test@example.com")
+	err := s.db.AddMailbox(context.Background(), "test@example.com")
 	s.NoError(err)
 
 	// Process mailboxes
@@ -75,6 +136,162 @@ func (s *WorkerTestSuite) TestProcessDueMailboxes_CommandFails() {
 	s.Len(mailboxes, 1)
 }
 
+func (s *WorkerTestSuite) TestProcessDueMailboxes_PrepareFails() {
+	s.purger.prepareErr = errors.New("doveadm user failed")
+
+	s.NoError(s.db.AddMailbox(context.Background(), "test@example.com"))
+
+	s.worker.processDueMailboxes()
+
+	// Nothing is purged that could not be verified afterwards
+	s.Empty(s.purger.purged)
+	mailboxes, err := s.db.GetDueMailboxes(0)
+	s.NoError(err)
+	s.Len(mailboxes, 1)
+}
+
+func (s *WorkerTestSuite) TestProcessDueMailboxes_ArchiveFails() {
+	identity, err := age.GenerateX25519Identity()
+	s.Require().NoError(err)
+
+	// Resolving the home directory fails with the echo doveadm
+	s.worker.archiver, err = NewArchiver(s.T().TempDir(), []string{identity.Recipient().String()}, nil, 0, NewDoveadmExec("/bin/echo", false), false)
+	s.Require().NoError(err)
+
+	err = s.db.AddMailbox(context.Background(), "test@example.com")
+	s.NoError(err)
+
+	s.worker.processDueMailboxes()
+
+	// Mailbox should still be in database because the export failed
+	mailboxes, err := s.db.GetDueMailboxes(0)
+	s.NoError(err)
+	s.Len(mailboxes, 1)
+	s.Empty(s.purger.purged)
+}
+
+func (s *WorkerTestSuite) TestProcessDueMailboxes_VerificationFails() {
+	s.purger.residual = "INBOX=3"
+
+	err := s.db.AddMailbox(context.Background(), "test@example.com")
+	s.NoError(err)
+
+	s.worker.processDueMailboxes()
+
+	// Mailbox stays in the queue with the residual counts
+	mailboxes, err := s.db.GetMailboxes()
+	s.NoError(err)
+	s.Require().Len(mailboxes, 1)
+	s.Equal(MailboxStateVerificationFailed, mailboxes[0].State)
+	s.Equal("INBOX=3", mailboxes[0].Residual)
+}
+
+func (s *WorkerTestSuite) TestProcessDueMailboxes_PreHookFails() {
+	s.worker.hooks = NewHooks("/bin/false", "", time.Second, HookFailureBlock)
+
+	s.NoError(s.db.AddMailbox(context.Background(), "test@example.com"))
+
+	s.worker.processDueMailboxes()
+
+	// Mailbox should still be in database because the pre-purge hook failed
+	mailboxes, err := s.db.GetDueMailboxes(0)
+	s.NoError(err)
+	s.Len(mailboxes, 1)
+	s.Empty(s.purger.purged)
+
+	// The ignore policy purges anyway
+	s.worker.hooks = NewHooks("/bin/false", "", time.Second, HookFailureIgnore)
+
+	s.worker.processDueMailboxes()
+
+	mailboxes, err = s.db.GetDueMailboxes(0)
+	s.NoError(err)
+	s.Empty(mailboxes)
+	s.Equal([]string{"test@example.com"}, s.purger.purged)
+}
+
+func (s *WorkerTestSuite) TestProcessDueMailboxes_DomainBackend() {
+	other := &fakePurger{}
+	s.worker.purgers = NewPurgers(s.purger, map[string]Purger{"example.org": other})
+
+	s.NoError(s.db.AddMailbox(context.Background(), "test@example.com"))
+	s.NoError(s.db.AddMailbox(context.Background(), "test@example.org"))
+
+	s.worker.processDueMailboxes()
+
+	s.Equal([]string{"test@example.com"}, s.purger.purged)
+	s.Equal([]string{"test@example.org"}, other.purged)
+}
+
+func (s *WorkerTestSuite) TestProcessDueMailboxes_Protected() {
+	// Protected entries may be added to the CSV manually
+	err := s.db.AddMailbox(context.Background(), "postmaster@example.com")
+	s.NoError(err)
+
+	// Process mailboxes
+	s.worker.processDueMailboxes()
+
+	// Mailbox should still be in database because it is protected
+	mailboxes, err := s.db.GetDueMailboxes(0)
+	s.NoError(err)
+	s.Len(mailboxes, 1)
+	s.Empty(s.purger.purged)
+}
+
+func (s *WorkerTestSuite) TestCheckTick() {
+	s.ErrorContains(s.worker.CheckTick(), "not ticked yet")
+
+	s.worker.processDueMailboxes()
+	s.NoError(s.worker.CheckTick())
+
+	s.worker.heartbeat.status.LastTickFinishedAt = time.Now().Add(-time.Second)
+	s.ErrorContains(s.worker.CheckTick(), "last tick finished")
+}
+
+func (s *WorkerTestSuite) TestCheckTick_LongRunningTick() {
+	s.worker.heartbeat.beginTick()
+	defer s.worker.heartbeat.endTick()
+
+	// A tick running longer than two intervals is fine until it exceeds the warn duration
+	s.worker.heartbeat.mu.Lock()
+	s.worker.heartbeat.status.LastTickStartedAt = time.Now().Add(-time.Hour)
+	s.worker.heartbeat.mu.Unlock()
+	s.NoError(s.worker.CheckTick())
+
+	s.worker.heartbeat.warnStalled()
+	s.ErrorContains(s.worker.CheckTick(), "tick running for")
+}
+
+func (s *WorkerTestSuite) TestTrigger_Coalesces() {
+	s.True(s.worker.Trigger("test"))
+	s.False(s.worker.Trigger("test"))
+	s.Len(s.worker.trigger, 1)
+}
+
+func (s *WorkerTestSuite) TestWorkerStart_Trigger() {
+	s.worker.tickInterval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.worker.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Wait for the run on start
+	s.Eventually(func() bool { return s.worker.Status().Ticks == 1 }, time.Second, 10*time.Millisecond)
+
+	s.NoError(s.db.AddMailbox(context.Background(), "test@example.com"))
+	s.worker.Trigger("test")
+
+	s.Eventually(func() bool { return s.worker.Status().Ticks == 2 }, time.Second, 10*time.Millisecond)
+	s.Equal([]string{"test@example.com"}, s.purger.purged)
+}
+
 func (s *WorkerTestSuite) TestWorkerStart_Stop() {
 	ctx, cancel := context.WithCancel(context.Background())
 